@@ -606,13 +606,7 @@ func (t *InboxTracker) AddSequencerBatches(ctx context.Context, client arbutil.L
 		ctx:    ctx,
 		client: client,
 	}
-	var daProviders []arbstate.DataAvailabilityProvider
-	if t.das != nil {
-		daProviders = append(daProviders, arbstate.NewDAProviderDAS(t.das))
-	}
-	if t.blobReader != nil {
-		daProviders = append(daProviders, arbstate.NewDAProviderBlobReader(t.blobReader))
-	}
+	daProviders := arbstate.NewDAProviderList(t.das, nil, t.blobReader)
 	multiplexer := arbstate.NewInboxMultiplexer(backend, prevbatchmeta.DelayedMessageCount, daProviders, arbstate.KeysetValidate)
 	batchMessageCounts := make(map[uint64]arbutil.MessageIndex)
 	currentpos := prevbatchmeta.MessageCount + 1
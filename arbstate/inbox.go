@@ -4,6 +4,7 @@
 package arbstate
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/binary"
@@ -11,11 +12,13 @@ import (
 	"fmt"
 	"io"
 	"math/big"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/crypto/kzg4844"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/rlp"
 
 	"github.com/offchainlabs/nitro/arbcompress"
@@ -28,6 +31,27 @@ import (
 	"github.com/offchainlabs/nitro/zeroheavy"
 )
 
+// parseFailureCounters counts sequencer message parse failures by class
+type parseFailureCounters struct {
+	brotliError          metrics.Counter
+	unknownHeaderByte    metrics.Counter
+	segmentOverflow      metrics.Counter
+	segmentBytesOverflow metrics.Counter
+	emptyMessage         metrics.Counter
+	missingDASReader     metrics.Counter
+}
+
+var parseCounters = &parseFailureCounters{
+	brotliError:          metrics.NewRegisteredCounter("arb/inbox/parse/brotli/error", nil),
+	unknownHeaderByte:    metrics.NewRegisteredCounter("arb/inbox/parse/unknownheader", nil),
+	segmentOverflow:      metrics.NewRegisteredCounter("arb/inbox/parse/segments/overflow", nil),
+	segmentBytesOverflow: metrics.NewRegisteredCounter("arb/inbox/parse/segments/bytes/overflow", nil),
+	emptyMessage:         metrics.NewRegisteredCounter("arb/inbox/parse/empty", nil),
+	missingDASReader:     metrics.NewRegisteredCounter("arb/inbox/parse/das/missing", nil),
+}
+
+var blobHeaderOnlyCounter = metrics.NewRegisteredCounter("arb/inbox/parse/blob/headeronly", nil)
+
 type InboxBackend interface {
 	PeekSequencerInbox() ([]byte, common.Hash, error)
 
@@ -59,11 +83,145 @@ type sequencerMessage struct {
 }
 
 const MaxDecompressedLen int = 1024 * 1024 * 16 // 16 MiB
-const maxZeroheavyDecompressedLen = 101*MaxDecompressedLen/100 + 64
 const MaxSegmentsPerSequencerMessage = 100 * 1024
 const MinLifetimeSecondsForDataAvailabilityCert = 7 * 24 * 60 * 60 // one week
 
-func parseSequencerMessage(ctx context.Context, batchNum uint64, batchBlockHash common.Hash, data []byte, daProviders []DataAvailabilityProvider, keysetValidationMode KeysetValidationMode) (*sequencerMessage, error) {
+// maxConfigurableDecompressedLen bounds InboxMultiplexerConfig.MaxDecompressedLen, as a buffer of that size is allocated for every batch
+const maxConfigurableDecompressedLen = 1024 * 1024 * 256 // 256 MiB
+
+var ErrNoMatchingDAProvider = errors.New("no DA provider or known format matches sequencer message header byte")
+
+// InboxMultiplexerConfig holds the bounds applied while parsing sequencer messages.
+// The replay binary always parses with DefaultInboxMultiplexerConfig, so a node using anything else can't be validated
+// or fraud proven; overriding it is only meant for tests and tooling which inspect batches.
+type InboxMultiplexerConfig struct {
+	MaxDecompressedLen             int
+	MaxSegmentsPerSequencerMessage int
+	// MaxSegmentBytesPerSequencerMessage caps the summed length of a batch's segments.
+	// The default matches MaxDecompressedLen, which the segments can't exceed anyway.
+	MaxSegmentBytesPerSequencerMessage int
+	// StrictHeaderBytes makes a payload in an unknown format fail with ErrNoMatchingDAProvider instead of
+	// becoming an empty batch. It's meant for debugging tools, such as through ParseBatchWithConfig; a node must not
+	// enable it, as it would stall on such a batch.
+	StrictHeaderBytes bool
+}
+
+var DefaultInboxMultiplexerConfig = InboxMultiplexerConfig{
+	MaxDecompressedLen:                 MaxDecompressedLen,
+	MaxSegmentsPerSequencerMessage:     MaxSegmentsPerSequencerMessage,
+	MaxSegmentBytesPerSequencerMessage: MaxDecompressedLen,
+}
+
+func (c *InboxMultiplexerConfig) Validate() error {
+	if c.MaxDecompressedLen <= 0 || c.MaxDecompressedLen > maxConfigurableDecompressedLen {
+		return fmt.Errorf("max decompressed length %v must be positive and at most %v", c.MaxDecompressedLen, maxConfigurableDecompressedLen)
+	}
+	if c.MaxSegmentsPerSequencerMessage <= 0 {
+		return fmt.Errorf("invalid max segments per sequencer message %v", c.MaxSegmentsPerSequencerMessage)
+	}
+	if c.MaxSegmentBytesPerSequencerMessage <= 0 {
+		return fmt.Errorf("invalid max segment bytes per sequencer message %v", c.MaxSegmentBytesPerSequencerMessage)
+	}
+	return nil
+}
+
+// maxZeroheavyDecompressedLen allows for the zeroheavy encoding overhead on top of MaxDecompressedLen
+func (c *InboxMultiplexerConfig) maxZeroheavyDecompressedLen() int {
+	return 101*c.MaxDecompressedLen/100 + 64
+}
+
+// BatchInfo describes a parsed sequencer message, for tooling that inspects batches outside of the inbox multiplexer
+type BatchInfo struct {
+	MinTimestamp         uint64
+	MaxTimestamp         uint64
+	MinL1Block           uint64
+	MaxL1Block           uint64
+	AfterDelayedMessages uint64
+	// HeaderByte is the first byte after the L1 header, or nil if the batch has no payload
+	HeaderByte *byte
+	// DAProvider is the provider the payload was recovered from, or nil if it was posted as calldata
+	DAProvider DataAvailabilityProvider
+	// SegmentKinds holds the kind byte of each segment, in order; empty segments are left out
+	SegmentKinds []uint8
+}
+
+// ParseBatch parses a sequencer message the same way the inbox multiplexer does, using the default parsing bounds.
+// A batch the multiplexer would treat as empty or invalid is reported with no segments rather than as an error.
+func ParseBatch(ctx context.Context, batchNum uint64, batchBlockHash common.Hash, data []byte, daProviders []DataAvailabilityProvider, keysetValidationMode KeysetValidationMode) (*BatchInfo, error) {
+	return ParseBatchWithConfig(ctx, batchNum, batchBlockHash, data, daProviders, keysetValidationMode, &DefaultInboxMultiplexerConfig)
+}
+
+// ParseBatchWithConfig is like ParseBatch but parses with the given bounds, e.g. with StrictHeaderBytes set
+func ParseBatchWithConfig(ctx context.Context, batchNum uint64, batchBlockHash common.Hash, data []byte, daProviders []DataAvailabilityProvider, keysetValidationMode KeysetValidationMode, config *InboxMultiplexerConfig) (*BatchInfo, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	parsedMsg, err := parseSequencerMessage(ctx, batchNum, batchBlockHash, data, daProviders, keysetValidationMode, config)
+	if err != nil {
+		return nil, err
+	}
+	info := &BatchInfo{
+		MinTimestamp:         parsedMsg.minTimestamp,
+		MaxTimestamp:         parsedMsg.maxTimestamp,
+		MinL1Block:           parsedMsg.minL1Block,
+		MaxL1Block:           parsedMsg.maxL1Block,
+		AfterDelayedMessages: parsedMsg.afterDelayedMessages,
+		SegmentKinds:         []uint8{},
+	}
+	if len(data) > 40 {
+		headerByte := data[40]
+		info.HeaderByte = &headerByte
+		for _, provider := range daProviders {
+			if provider != nil && provider.IsValidHeaderByte(headerByte) {
+				info.DAProvider = provider
+				break
+			}
+		}
+	}
+	for _, segment := range parsedMsg.segments {
+		if len(segment) > 0 {
+			info.SegmentKinds = append(info.SegmentKinds, segment[0])
+		}
+	}
+	return info, nil
+}
+
+// contextReaderChunkSize is how much decodeZeroheavy reads between context checks
+const contextReaderChunkSize = 64 * 1024
+
+// contextReader fails reads once its context is done, so that long decoding loops stop promptly on shutdown.
+// Checking the context isn't free, so a consumer reading a byte at a time should read through a buffer.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+// decodeZeroheavy decodes at most maxLen bytes of the zeroheavy encoded source, stopping early once ctx is done.
+// The decoder reads its source a byte at a time, so the context is only checked once per buffered chunk.
+func decodeZeroheavy(ctx context.Context, source io.Reader, maxLen int) ([]byte, error) {
+	reader := bufio.NewReaderSize(&contextReader{ctx, source}, contextReaderChunkSize)
+	return io.ReadAll(io.LimitReader(zeroheavy.NewZeroheavyDecoder(reader), int64(maxLen)))
+}
+
+// batchLogContext prepends the batch number and block hash to a log line's key/value context,
+// so that warnings about a batch can be traced back to it
+func batchLogContext(batchNum uint64, batchBlockHash common.Hash, ctx ...interface{}) []interface{} {
+	return append([]interface{}{"batchNum", batchNum, "batchBlockHash", batchBlockHash}, ctx...)
+}
+
+func parseSequencerMessage(ctx context.Context, batchNum uint64, batchBlockHash common.Hash, data []byte, daProviders []DataAvailabilityProvider, keysetValidationMode KeysetValidationMode, config *InboxMultiplexerConfig) (*sequencerMessage, error) {
+	return parseSequencerMessageWithCounters(ctx, batchNum, batchBlockHash, data, daProviders, keysetValidationMode, config, parseCounters)
+}
+
+// parseSequencerMessageWithCounters is parseSequencerMessage counting its failures in the given counters
+func parseSequencerMessageWithCounters(ctx context.Context, batchNum uint64, batchBlockHash common.Hash, data []byte, daProviders []DataAvailabilityProvider, keysetValidationMode KeysetValidationMode, config *InboxMultiplexerConfig, counters *parseFailureCounters) (*sequencerMessage, error) {
 	if len(data) < 40 {
 		return nil, errors.New("sequencer message missing L1 header")
 	}
@@ -89,12 +247,21 @@ func parseSequencerMessage(ctx context.Context, batchNum uint64, batchBlockHash
 	// It's important that multiple DAS strategies can't both be invoked in the same batch,
 	// as these headers are validated by the sequencer inbox and not other DASs.
 	// We try to extract payload from the first occuring valid DA provider in the daProviders list
+	// The recovered payload must still begin with its own header byte, as it goes through stages 2 and 3 below
+	// just like calldata does; a provider storing brotli-compressed data returns it with BrotliMessageHeaderByte in front.
+	// Its size is bounded by the decoding limits of those stages rather than here, as zeroheavy or brotli encoded data
+	// may be larger than MaxDecompressedLen and still decode within it.
+	missingDASReader := false
 	if len(payload) > 0 {
 		foundDA := false
 		var err error
 		for _, provider := range daProviders {
 			if provider != nil && provider.IsValidHeaderByte(payload[0]) {
 				payload, err = provider.RecoverPayloadFromBatch(ctx, batchNum, batchBlockHash, data, nil, keysetValidationMode)
+				if errors.Is(err, ErrInvalidDasBatch) {
+					// The batch was posted but failed validation, which has always made it an empty batch
+					return parsedMsg, nil
+				}
 				if err != nil {
 					return nil, err
 				}
@@ -108,7 +275,9 @@ func parseSequencerMessage(ctx context.Context, batchNum uint64, batchBlockHash
 
 		if !foundDA {
 			if IsDASMessageHeaderByte(payload[0]) {
-				log.Error("No DAS Reader configured, but sequencer message found with DAS header")
+				counters.missingDASReader.Inc(1)
+				missingDASReader = true
+				log.Error("No DAS Reader configured, but sequencer message found with DAS header", batchLogContext(batchNum, batchBlockHash)...)
 			} else if IsBlobHashesHeaderByte(payload[0]) {
 				return nil, errors.New("blob batch payload was encountered but no BlobReader was configured")
 			}
@@ -118,46 +287,77 @@ func parseSequencerMessage(ctx context.Context, batchNum uint64, batchBlockHash
 	// At this point, `payload` has not been validated by the sequencer inbox at all.
 	// It's not safe to trust any part of the payload from this point onwards.
 
+	// Decoding a large payload can take a while. A cancelled context is returned as a real error rather
+	// than turning the batch into an empty one, so that the batch is parsed again once the node restarts.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Stage 2: If enabled, decode the zero heavy payload (saves gas based on calldata charging).
 	if len(payload) > 0 && IsZeroheavyEncodedHeaderByte(payload[0]) {
-		pl, err := io.ReadAll(io.LimitReader(zeroheavy.NewZeroheavyDecoder(bytes.NewReader(payload[1:])), int64(maxZeroheavyDecompressedLen)))
+		pl, err := decodeZeroheavy(ctx, bytes.NewReader(payload[1:]), config.maxZeroheavyDecompressedLen())
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		// The decoder reports errors from its source as EOF, so this isn't expected to happen
 		if err != nil {
-			log.Warn("error reading from zeroheavy decoder", err.Error())
+			log.Warn("error reading from zeroheavy decoder", batchLogContext(batchNum, batchBlockHash, "err", err)...)
 			return parsedMsg, nil
 		}
 		payload = pl
 	}
 
 	// Stage 3: Decompress the brotli payload and fill the parsedMsg.segments list.
+	// This is the only place segments come from, so its limits apply whichever DA provider the payload came from.
 	if len(payload) > 0 && IsBrotliMessageHeaderByte(payload[0]) {
-		decompressed, err := arbcompress.Decompress(payload[1:], MaxDecompressedLen)
+		decompressed, err := arbcompress.Decompress(payload[1:], config.MaxDecompressedLen)
 		if err == nil {
 			reader := bytes.NewReader(decompressed)
-			stream := rlp.NewStream(reader, uint64(MaxDecompressedLen))
+			stream := rlp.NewStream(reader, uint64(config.MaxDecompressedLen))
+			segmentBytes := 0
 			for {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
 				var segment []byte
 				err := stream.Decode(&segment)
 				if err != nil {
 					if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
-						log.Warn("error parsing sequencer message segment", "err", err.Error())
+						log.Warn("error parsing sequencer message segment", batchLogContext(batchNum, batchBlockHash, "err", err)...)
 					}
 					break
 				}
-				if len(parsedMsg.segments) >= MaxSegmentsPerSequencerMessage {
-					log.Warn("too many segments in sequence batch")
+				if len(parsedMsg.segments) >= config.MaxSegmentsPerSequencerMessage {
+					counters.segmentOverflow.Inc(1)
+					log.Warn("too many segments in sequence batch", batchLogContext(batchNum, batchBlockHash, "limit", config.MaxSegmentsPerSequencerMessage)...)
+					break
+				}
+				if segmentBytes+len(segment) > config.MaxSegmentBytesPerSequencerMessage {
+					counters.segmentBytesOverflow.Inc(1)
+					log.Warn("too many segment bytes in sequence batch", batchLogContext(batchNum, batchBlockHash, "limit", config.MaxSegmentBytesPerSequencerMessage)...)
 					break
 				}
+				segmentBytes += len(segment)
 				parsedMsg.segments = append(parsedMsg.segments, segment)
 			}
 		} else {
-			log.Warn("sequencer msg decompression failed", "err", err)
+			counters.brotliError.Inc(1)
+			log.Warn("sequencer msg decompression failed", batchLogContext(batchNum, batchBlockHash, "err", err)...)
 		}
 	} else {
 		length := len(payload)
 		if length == 0 {
-			log.Warn("empty sequencer message")
+			counters.emptyMessage.Inc(1)
+			log.Warn("empty sequencer message", batchLogContext(batchNum, batchBlockHash)...)
 		} else {
-			log.Warn("unknown sequencer message format", "length", length, "firstByte", payload[0])
+			if !missingDASReader {
+				// A DAS batch without a DAS reader was already counted above
+				counters.unknownHeaderByte.Inc(1)
+			}
+			if config.StrictHeaderBytes {
+				return nil, fmt.Errorf("%w: 0x%02x", ErrNoMatchingDAProvider, payload[0])
+			}
+			log.Warn("unknown sequencer message format", batchLogContext(batchNum, batchBlockHash, "length", length, "firstByte", payload[0])...)
 		}
 
 	}
@@ -165,6 +365,40 @@ func parseSequencerMessage(ctx context.Context, batchNum uint64, batchBlockHash
 	return parsedMsg, nil
 }
 
+// ErrInvalidDasBatch is wrapped by every error which means the DAS batch must be treated as empty.
+// Unlike fetch errors, these are deterministic and retrying won't help.
+var ErrInvalidDasBatch = errors.New("invalid DAS batch")
+
+var (
+	ErrBadDasCert                = fmt.Errorf("%w: failed to deserialize DAS certificate", ErrInvalidDasBatch)
+	ErrUnsupportedDasCertVersion = fmt.Errorf("%w: unsupported DAS certificate version", ErrInvalidDasBatch)
+	ErrBadDasKeyset              = fmt.Errorf("%w: failed to deserialize DAS keyset", ErrInvalidDasBatch)
+	ErrBadDasSignature           = fmt.Errorf("%w: bad signature on DAS batch", ErrInvalidDasBatch)
+	ErrCertExpiresTooSoon        = fmt.Errorf("%w: data availability cert expires too soon", ErrInvalidDasBatch)
+)
+
+// ErrDasFetchTimeout is returned when a single GetByHash exceeds DASRecoveryConfig.FetchTimeout.
+// It's a fetch error, so it doesn't wrap ErrInvalidDasBatch and the batch is retried.
+var ErrDasFetchTimeout = errors.New("timed out fetching DAS preimage")
+
+// DASRecoveryConfig holds the DAS batch recovery parameters which may differ between nodes.
+// Nothing in it may change which batches are valid, as the replay binary always recovers with the defaults.
+type DASRecoveryConfig struct {
+	// FetchTimeout bounds each GetByHash call, or is 0 for no limit besides the context's.
+	// It only affects how quickly a slow reader is given up on, so nodes needn't agree on it.
+	FetchTimeout time.Duration
+}
+
+var DefaultDASRecoveryConfig = DASRecoveryConfig{}
+
+func (c *DASRecoveryConfig) Validate() error {
+	if c.FetchTimeout < 0 {
+		return fmt.Errorf("invalid DAS fetch timeout %v", c.FetchTimeout)
+	}
+	return nil
+}
+
+// RecoverPayloadFromDasBatch returns an error wrapping ErrInvalidDasBatch if the batch should be treated as empty
 func RecoverPayloadFromDasBatch(
 	ctx context.Context,
 	batchNum uint64,
@@ -172,6 +406,19 @@ func RecoverPayloadFromDasBatch(
 	dasReader DataAvailabilityReader,
 	preimages map[arbutil.PreimageType]map[common.Hash][]byte,
 	keysetValidationMode KeysetValidationMode,
+) ([]byte, error) {
+	return RecoverPayloadFromDasBatchWithConfig(ctx, batchNum, sequencerMsg, dasReader, preimages, keysetValidationMode, &DefaultDASRecoveryConfig)
+}
+
+// RecoverPayloadFromDasBatchWithConfig is like RecoverPayloadFromDasBatch but with non-default recovery parameters
+func RecoverPayloadFromDasBatchWithConfig(
+	ctx context.Context,
+	batchNum uint64,
+	sequencerMsg []byte,
+	dasReader DataAvailabilityReader,
+	preimages map[arbutil.PreimageType]map[common.Hash][]byte,
+	keysetValidationMode KeysetValidationMode,
+	config *DASRecoveryConfig,
 ) ([]byte, error) {
 	var keccakPreimages map[common.Hash][]byte
 	if preimages != nil {
@@ -182,8 +429,8 @@ func RecoverPayloadFromDasBatch(
 	}
 	cert, err := DeserializeDASCertFrom(bytes.NewReader(sequencerMsg[40:]))
 	if err != nil {
-		log.Error("Failed to deserialize DAS message", "err", err)
-		return nil, nil
+		log.Error("Failed to deserialize DAS message", "err", err, "batchNum", batchNum)
+		return nil, fmt.Errorf("%w: %v", ErrBadDasCert, err)
 	}
 	version := cert.Version
 	recordPreimage := func(key common.Hash, value []byte) {
@@ -191,8 +438,21 @@ func RecoverPayloadFromDasBatch(
 	}
 
 	if version >= 2 {
-		log.Error("Your node software is probably out of date", "certificateVersion", version)
-		return nil, nil
+		log.Error("Your node software is probably out of date", "certificateVersion", version, "batchNum", batchNum)
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedDasCertVersion, version)
+	}
+
+	fetch := func(ctx context.Context, hash common.Hash) ([]byte, error) {
+		if config.FetchTimeout <= 0 {
+			return dasReader.GetByHash(ctx, hash)
+		}
+		fetchCtx, cancel := context.WithTimeout(ctx, config.FetchTimeout)
+		defer cancel()
+		preimage, err := dasReader.GetByHash(fetchCtx, hash)
+		if err != nil && ctx.Err() == nil && errors.Is(fetchCtx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: hash %v after %v", ErrDasFetchTimeout, hash, config.FetchTimeout)
+		}
+		return preimage, err
 	}
 
 	getByHash := func(ctx context.Context, hash common.Hash) ([]byte, error) {
@@ -201,31 +461,41 @@ func RecoverPayloadFromDasBatch(
 			newHash = dastree.FlatHashToTreeHash(hash)
 		}
 
-		preimage, err := dasReader.GetByHash(ctx, newHash)
+		preimageMatches := func(preimage []byte) bool {
+			if version == 0 {
+				return crypto.Keccak256Hash(preimage) == hash
+			}
+			return dastree.Hash(preimage) == hash
+		}
+
+		// A mismatching preimage under the new style hash is treated like a failed fetch, as mixed-era
+		// storage may hold something else there while still having the data under the old hash
+		preimage, err := fetch(ctx, newHash)
+		if err == nil && !preimageMatches(preimage) {
+			err = ErrHashMismatch
+		}
 		if err != nil && hash != newHash {
 			log.Debug("error fetching new style hash, trying old", "new", newHash, "old", hash, "err", err)
-			preimage, err = dasReader.GetByHash(ctx, hash)
-		}
-		if err != nil {
-			return nil, err
+			preimage, err = fetch(ctx, hash)
+			if err == nil && !preimageMatches(preimage) {
+				err = ErrHashMismatch
+			}
 		}
-
-		switch {
-		case version == 0 && crypto.Keccak256Hash(preimage) != hash:
-			fallthrough
-		case version == 1 && dastree.Hash(preimage) != hash:
+		if errors.Is(err, ErrHashMismatch) {
 			log.Error(
 				"preimage mismatch for hash",
 				"hash", hash, "err", ErrHashMismatch, "version", version,
 			)
-			return nil, ErrHashMismatch
+		}
+		if err != nil {
+			return nil, err
 		}
 		return preimage, nil
 	}
 
 	keysetPreimage, err := getByHash(ctx, cert.KeysetHash)
 	if err != nil {
-		log.Error("Couldn't get keyset", "err", err)
+		log.Error("Couldn't get keyset", "err", err, "batchNum", batchNum)
 		return nil, err
 	}
 	if keccakPreimages != nil {
@@ -239,24 +509,24 @@ func RecoverPayloadFromDasBatch(
 			logLevel = log.Crit
 		}
 		logLevel("Couldn't deserialize keyset", "err", err, "keysetHash", cert.KeysetHash, "batchNum", batchNum)
-		return nil, nil
+		return nil, fmt.Errorf("%w: %v", ErrBadDasKeyset, err)
 	}
 	err = keyset.VerifySignature(cert.SignersMask, cert.SerializeSignableFields(), cert.Sig)
 	if err != nil {
-		log.Error("Bad signature on DAS batch", "err", err)
-		return nil, nil
+		log.Error("Bad signature on DAS batch", "err", err, "batchNum", batchNum)
+		return nil, fmt.Errorf("%w: %v", ErrBadDasSignature, err)
 	}
 
 	maxTimestamp := binary.BigEndian.Uint64(sequencerMsg[8:16])
 	if cert.Timeout < maxTimestamp+MinLifetimeSecondsForDataAvailabilityCert {
-		log.Error("Data availability cert expires too soon", "err", "")
-		return nil, nil
+		log.Error("Data availability cert expires too soon", "err", "", "batchNum", batchNum)
+		return nil, fmt.Errorf("%w: timeout %v is before %v", ErrCertExpiresTooSoon, cert.Timeout, maxTimestamp+MinLifetimeSecondsForDataAvailabilityCert)
 	}
 
 	dataHash := cert.DataHash
 	payload, err := getByHash(ctx, dataHash)
 	if err != nil {
-		log.Error("Couldn't fetch DAS batch contents", "err", err)
+		log.Error("Couldn't fetch DAS batch contents", "err", err, "batchNum", batchNum)
 		return nil, err
 	}
 
@@ -292,12 +562,25 @@ type DataAvailabilityProvider interface {
 // DA Providers should implement methods in the DataAvailabilityProvider interface independently
 func NewDAProviderDAS(das DataAvailabilityReader) *dAProviderForDAS {
 	return &dAProviderForDAS{
-		das: das,
+		das:    das,
+		config: &DefaultDASRecoveryConfig,
 	}
 }
 
+// NewDAProviderDASWithConfig is like NewDAProviderDAS but with non-default recovery parameters
+func NewDAProviderDASWithConfig(das DataAvailabilityReader, config *DASRecoveryConfig) (*dAProviderForDAS, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &dAProviderForDAS{
+		das:    das,
+		config: config,
+	}, nil
+}
+
 type dAProviderForDAS struct {
-	das DataAvailabilityReader
+	das    DataAvailabilityReader
+	config *DASRecoveryConfig
 }
 
 func (d *dAProviderForDAS) IsValidHeaderByte(headerByte byte) bool {
@@ -312,19 +595,57 @@ func (d *dAProviderForDAS) RecoverPayloadFromBatch(
 	preimages map[arbutil.PreimageType]map[common.Hash][]byte,
 	keysetValidationMode KeysetValidationMode,
 ) ([]byte, error) {
-	return RecoverPayloadFromDasBatch(ctx, batchNum, sequencerMsg, d.das, preimages, keysetValidationMode)
+	return RecoverPayloadFromDasBatchWithConfig(ctx, batchNum, sequencerMsg, d.das, preimages, keysetValidationMode, d.config)
+}
+
+// NewDAProviderWithKeysetValidationMode wraps a provider so that it recovers payloads with the given keyset validation mode,
+// rather than the mode the inbox multiplexer was constructed with. This lets e.g. DAS panic on an invalid keyset
+// while another provider in the same multiplexer only treats such a batch as empty.
+// Replay can't be given the wrapper, so only KeysetValidate and KeysetPanicIfInvalid are accepted: they agree on which
+// batches are valid, while KeysetDontValidate would accept keysets replay rejects. A KeysetDontValidate passed in by
+// the multiplexer is never overridden, as replay switches to it when it resumes a batch whose keyset was already checked.
+func NewDAProviderWithKeysetValidationMode(provider DataAvailabilityProvider, keysetValidationMode KeysetValidationMode) (DataAvailabilityProvider, error) {
+	if keysetValidationMode != KeysetValidate && keysetValidationMode != KeysetPanicIfInvalid {
+		return nil, fmt.Errorf("keyset validation mode %v can't be set per DA provider", keysetValidationMode)
+	}
+	return &dAProviderWithKeysetValidationMode{
+		DataAvailabilityProvider: provider,
+		keysetValidationMode:     keysetValidationMode,
+	}, nil
+}
+
+type dAProviderWithKeysetValidationMode struct {
+	DataAvailabilityProvider
+	keysetValidationMode KeysetValidationMode
+}
+
+func (p *dAProviderWithKeysetValidationMode) RecoverPayloadFromBatch(
+	ctx context.Context,
+	batchNum uint64,
+	batchBlockHash common.Hash,
+	sequencerMsg []byte,
+	preimages map[arbutil.PreimageType]map[common.Hash][]byte,
+	keysetValidationMode KeysetValidationMode,
+) ([]byte, error) {
+	if keysetValidationMode != KeysetDontValidate {
+		keysetValidationMode = p.keysetValidationMode
+	}
+	return p.DataAvailabilityProvider.RecoverPayloadFromBatch(ctx, batchNum, batchBlockHash, sequencerMsg, preimages, keysetValidationMode)
 }
 
 // NewDAProviderBlobReader is generally meant to be only used by nitro.
 // DA Providers should implement methods in the DataAvailabilityProvider interface independently
 func NewDAProviderBlobReader(blobReader BlobReader) *dAProviderForBlobReader {
 	return &dAProviderForBlobReader{
-		blobReader: blobReader,
+		blobReader:        blobReader,
+		headerOnlyCounter: blobHeaderOnlyCounter,
 	}
 }
 
 type dAProviderForBlobReader struct {
 	blobReader BlobReader
+	// headerOnlyCounter counts blob batches without any versioned hashes
+	headerOnlyCounter metrics.Counter
 }
 
 func (b *dAProviderForBlobReader) IsValidHeaderByte(headerByte byte) bool {
@@ -340,6 +661,12 @@ func (b *dAProviderForBlobReader) RecoverPayloadFromBatch(
 	keysetValidationMode KeysetValidationMode,
 ) ([]byte, error) {
 	blobHashes := sequencerMsg[41:]
+	if len(blobHashes) == 0 {
+		// A header byte with nothing after it is a degenerate batch; treat it as empty rather than asking for zero blobs
+		b.headerOnlyCounter.Inc(1)
+		log.Warn("blob batch has no versioned hashes, treating as empty batch", batchLogContext(batchNum, batchBlockHash)...)
+		return nil, nil
+	}
 	if len(blobHashes)%len(common.Hash{}) != 0 {
 		return nil, fmt.Errorf("blob batch data is not a list of hashes as expected")
 	}
@@ -348,42 +675,104 @@ func (b *dAProviderForBlobReader) RecoverPayloadFromBatch(
 		copy(versionedHashes[i][:], blobHashes[i*32:(i+1)*32])
 	}
 	kzgBlobs, err := b.blobReader.GetBlobs(ctx, batchBlockHash, versionedHashes)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		// Whatever the blob reader returned, a cancelled fetch says nothing about the batch, so it must be retried
+		return nil, fmt.Errorf("failed to get blobs: %w", ctxErr)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get blobs: %w", err)
 	}
+	if len(kzgBlobs) != len(versionedHashes) {
+		// This is a fault of the blob reader rather than the batch, so it must not be treated as an empty batch
+		log.Warn("Blob reader returned wrong number of blobs", batchLogContext(batchNum, batchBlockHash, "blobs", len(kzgBlobs), "versionedHashes", len(versionedHashes))...)
+		return nil, fmt.Errorf("blob reader returned %v blobs for %v versioned hashes", len(kzgBlobs), len(versionedHashes))
+	}
 	payload, err := blobs.DecodeBlobs(kzgBlobs)
 	if err != nil {
-		log.Warn("Failed to decode blobs", "batchBlockHash", batchBlockHash, "versionedHashes", versionedHashes, "err", err)
+		var decodeErr *blobs.DecodeBlobsError
+		if errors.Is(err, blobs.ErrMissingBlobs) {
+			log.Warn("Blob batch data runs past its last blob", batchLogContext(batchNum, batchBlockHash, "versionedHashes", versionedHashes, "err", err)...)
+		} else if errors.As(err, &decodeErr) && decodeErr.BlobIndex < len(versionedHashes) {
+			log.Warn("Failed to decode blobs", batchLogContext(batchNum, batchBlockHash, "failedVersionedHash", versionedHashes[decodeErr.BlobIndex], "blobIndex", decodeErr.BlobIndex, "versionedHashes", versionedHashes, "err", err)...)
+		} else {
+			log.Warn("Failed to decode blobs", batchLogContext(batchNum, batchBlockHash, "versionedHashes", versionedHashes, "err", err)...)
+		}
 		return nil, nil
 	}
 	return payload, nil
 }
 
+// NewDAProviderList builds the providers to hand to NewInboxMultiplexer, skipping any which are nil.
+// parseSequencerMessage uses the first provider whose IsValidHeaderByte accepts a batch's header byte,
+// so the order decides which provider wins should two of them accept the same byte (e.g. 0xd0 has both the DAS and blob bits).
+// DAS comes first, then external, then blobs. An external provider, such as one for a separate DA layer,
+// must only accept header bytes which neither DAS nor the blob reader do, or batches of theirs would change meaning.
+func NewDAProviderList(das DataAvailabilityReader, external DataAvailabilityProvider, blobReader BlobReader) []DataAvailabilityProvider {
+	var daProviders []DataAvailabilityProvider
+	if das != nil {
+		daProviders = append(daProviders, NewDAProviderDAS(das))
+	}
+	if external != nil {
+		daProviders = append(daProviders, external)
+	}
+	if blobReader != nil {
+		daProviders = append(daProviders, NewDAProviderBlobReader(blobReader))
+	}
+	return daProviders
+}
+
 type KeysetValidationMode uint8
 
 const KeysetValidate KeysetValidationMode = 0
 const KeysetPanicIfInvalid KeysetValidationMode = 1
 const KeysetDontValidate KeysetValidationMode = 2
 
+// InboxMultiplexer is the arbostypes.InboxMultiplexer built by NewInboxMultiplexer,
+// along with the methods tooling may use to inspect it
+type InboxMultiplexer interface {
+	arbostypes.InboxMultiplexer
+	CurrentBatchBounds() (minTimestamp, maxTimestamp, minL1Block, maxL1Block, afterDelayedMessages uint64, ok bool)
+	PeekNextKind(ctx context.Context) (uint8, error)
+	Reset(delayedMessagesRead uint64)
+	DebugState() MultiplexerState
+}
+
 type inboxMultiplexer struct {
 	backend                   InboxBackend
 	delayedMessagesRead       uint64
 	daProviders               []DataAvailabilityProvider
 	cachedSequencerMessage    *sequencerMessage
 	cachedSequencerMessageNum uint64
+	cachedSequencerBlockHash  common.Hash
 	cachedSegmentNum          uint64
 	cachedSegmentTimestamp    uint64
 	cachedSegmentBlockNumber  uint64
 	cachedSubMessageNumber    uint64
 	keysetValidationMode      KeysetValidationMode
+	config                    InboxMultiplexerConfig
+}
+
+func NewInboxMultiplexer(backend InboxBackend, delayedMessagesRead uint64, daProviders []DataAvailabilityProvider, keysetValidationMode KeysetValidationMode) InboxMultiplexer {
+	return newInboxMultiplexer(backend, delayedMessagesRead, daProviders, keysetValidationMode, &DefaultInboxMultiplexerConfig)
+}
+
+// NewInboxMultiplexerWithConfig is like NewInboxMultiplexer but overrides the default parsing bounds.
+// The config is copied, so changing it afterwards doesn't affect the multiplexer. See InboxMultiplexerConfig for
+// why this isn't suitable for a node.
+func NewInboxMultiplexerWithConfig(backend InboxBackend, delayedMessagesRead uint64, daProviders []DataAvailabilityProvider, keysetValidationMode KeysetValidationMode, config *InboxMultiplexerConfig) (InboxMultiplexer, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return newInboxMultiplexer(backend, delayedMessagesRead, daProviders, keysetValidationMode, config), nil
 }
 
-func NewInboxMultiplexer(backend InboxBackend, delayedMessagesRead uint64, daProviders []DataAvailabilityProvider, keysetValidationMode KeysetValidationMode) arbostypes.InboxMultiplexer {
+func newInboxMultiplexer(backend InboxBackend, delayedMessagesRead uint64, daProviders []DataAvailabilityProvider, keysetValidationMode KeysetValidationMode, config *InboxMultiplexerConfig) *inboxMultiplexer {
 	return &inboxMultiplexer{
 		backend:              backend,
 		delayedMessagesRead:  delayedMessagesRead,
 		daProviders:          daProviders,
 		keysetValidationMode: keysetValidationMode,
+		config:               *config,
 	}
 }
 
@@ -396,18 +785,8 @@ const BatchSegmentKindAdvanceL1BlockNumber uint8 = 4
 // Pop returns the message from the top of the sequencer inbox and removes it from the queue.
 // Note: this does *not* return parse errors, those are transformed into invalid messages
 func (r *inboxMultiplexer) Pop(ctx context.Context) (*arbostypes.MessageWithMetadata, error) {
-	if r.cachedSequencerMessage == nil {
-		// Note: batchBlockHash will be zero in the replay binary, but that's fine
-		bytes, batchBlockHash, realErr := r.backend.PeekSequencerInbox()
-		if realErr != nil {
-			return nil, realErr
-		}
-		r.cachedSequencerMessageNum = r.backend.GetSequencerInboxPosition()
-		var err error
-		r.cachedSequencerMessage, err = parseSequencerMessage(ctx, r.cachedSequencerMessageNum, batchBlockHash, bytes, r.daProviders, r.keysetValidationMode)
-		if err != nil {
-			return nil, err
-		}
+	if err := r.loadSequencerMessage(ctx); err != nil {
+		return nil, err
 	}
 	msg, err := r.getNextMsg()
 	// advance even if there was an error
@@ -426,6 +805,39 @@ func (r *inboxMultiplexer) Pop(ctx context.Context) (*arbostypes.MessageWithMeta
 	return msg, err
 }
 
+// PeekNextKind returns the segment kind of the message the next Pop will return, without consuming it.
+// Like Pop it loads the sequencer message from the backend if none is cached, but it doesn't move any cursors.
+// A virtual delayed message after the end of the batch is reported as BatchSegmentKindDelayedMessages,
+// even if Pop will turn it into an invalid message because the batch's delayed messages were all read.
+func (r *inboxMultiplexer) PeekNextKind(ctx context.Context) (uint8, error) {
+	if err := r.loadSequencerMessage(ctx); err != nil {
+		return 0, err
+	}
+	segments := r.cachedSequencerMessage.segments
+	segmentNum, _, _, _ := r.seekSegment()
+	if segmentNum >= uint64(len(segments)) {
+		return BatchSegmentKindDelayedMessages, nil
+	}
+	return segments[int(segmentNum)][0], nil
+}
+
+// loadSequencerMessage parses and caches the sequencer message at the backend's inbox position if none is cached
+func (r *inboxMultiplexer) loadSequencerMessage(ctx context.Context) error {
+	if r.cachedSequencerMessage != nil {
+		return nil
+	}
+	// Note: batchBlockHash will be zero in the replay binary, but that's fine
+	bytes, batchBlockHash, realErr := r.backend.PeekSequencerInbox()
+	if realErr != nil {
+		return realErr
+	}
+	r.cachedSequencerMessageNum = r.backend.GetSequencerInboxPosition()
+	r.cachedSequencerBlockHash = batchBlockHash
+	var err error
+	r.cachedSequencerMessage, err = parseSequencerMessage(ctx, r.cachedSequencerMessageNum, batchBlockHash, bytes, r.daProviders, r.keysetValidationMode, &r.config)
+	return err
+}
+
 func (r *inboxMultiplexer) advanceSequencerMsg() {
 	if r.cachedSequencerMessage != nil {
 		r.delayedMessagesRead = r.cachedSequencerMessage.afterDelayedMessages
@@ -433,6 +845,26 @@ func (r *inboxMultiplexer) advanceSequencerMsg() {
 	r.backend.SetPositionWithinMessage(0)
 	r.backend.AdvanceSequencerInbox()
 	r.cachedSequencerMessage = nil
+	r.cachedSequencerMessageNum = 0
+	r.cachedSequencerBlockHash = common.Hash{}
+	r.cachedSegmentNum = 0
+	r.cachedSegmentTimestamp = 0
+	r.cachedSegmentBlockNumber = 0
+	r.cachedSubMessageNumber = 0
+}
+
+// logContext is batchLogContext for the cached sequencer message
+func (r *inboxMultiplexer) logContext(ctx ...interface{}) []interface{} {
+	return batchLogContext(r.cachedSequencerMessageNum, r.cachedSequencerBlockHash, ctx...)
+}
+
+// Reset drops the cached sequencer message and cursors and sets the delayed message count, as if the multiplexer
+// was newly constructed. The backend isn't touched, so the next Pop reads from the backend's current position.
+func (r *inboxMultiplexer) Reset(delayedMessagesRead uint64) {
+	r.delayedMessagesRead = delayedMessagesRead
+	r.cachedSequencerMessage = nil
+	r.cachedSequencerMessageNum = 0
+	r.cachedSequencerBlockHash = common.Hash{}
 	r.cachedSegmentNum = 0
 	r.cachedSegmentTimestamp = 0
 	r.cachedSegmentBlockNumber = 0
@@ -444,6 +876,10 @@ func (r *inboxMultiplexer) advanceSubMsg() {
 	r.backend.SetPositionWithinMessage(prevPos + 1)
 }
 
+// IsCachedSegementLast is called after getNextMsg and reports whether the message it returned is the last one of the batch.
+// While fewer than afterDelayedMessages delayed messages have been read, more messages remain even once the real segments
+// are exhausted, as getNextMsg then issues "virtual" delayed message segments. Otherwise only a later L2 or delayed message
+// segment keeps the batch going; trailing AdvanceTimestamp/AdvanceL1BlockNumber segments produce no messages.
 func (r *inboxMultiplexer) IsCachedSegementLast() bool {
 	seqMsg := r.cachedSequencerMessage
 	// we issue delayed messages until reaching afterDelayedMessages
@@ -466,21 +902,21 @@ func (r *inboxMultiplexer) IsCachedSegementLast() bool {
 	return true
 }
 
-// Returns a message, the segment number that had this message, and real/backend errors
-// parsing errors will be reported to log, return nil msg and nil error
-func (r *inboxMultiplexer) getNextMsg() (*arbostypes.MessageWithMetadata, error) {
+// seekSegment walks the cached sequencer message from the cached cursor up to the segment of the sub message
+// at the backend's position within the message, accumulating any timestamp and L1 block number advances on the way.
+// It doesn't modify the multiplexer; a segmentNum past the last segment means a virtual delayed message is next.
+func (r *inboxMultiplexer) seekSegment() (segmentNum uint64, timestamp uint64, blockNumber uint64, submessageNumber uint64) {
 	targetSubMessage := r.backend.GetPositionWithinMessage()
 	seqMsg := r.cachedSequencerMessage
-	segmentNum := r.cachedSegmentNum
-	timestamp := r.cachedSegmentTimestamp
-	blockNumber := r.cachedSegmentBlockNumber
-	submessageNumber := r.cachedSubMessageNumber
-	var segment []byte
+	segmentNum = r.cachedSegmentNum
+	timestamp = r.cachedSegmentTimestamp
+	blockNumber = r.cachedSegmentBlockNumber
+	submessageNumber = r.cachedSubMessageNumber
 	for {
 		if segmentNum >= uint64(len(seqMsg.segments)) {
 			break
 		}
-		segment = seqMsg.segments[int(segmentNum)]
+		segment := seqMsg.segments[int(segmentNum)]
 		if len(segment) == 0 {
 			segmentNum++
 			continue
@@ -490,7 +926,7 @@ func (r *inboxMultiplexer) getNextMsg() (*arbostypes.MessageWithMetadata, error)
 			rd := bytes.NewReader(segment[1:])
 			advancing, err := rlp.NewStream(rd, 16).Uint64()
 			if err != nil {
-				log.Warn("error parsing sequencer advancing segment", "err", err)
+				log.Warn("error parsing sequencer advancing segment", r.logContext("segmentNum", segmentNum, "err", err)...)
 				segmentNum++
 				continue
 			}
@@ -507,6 +943,14 @@ func (r *inboxMultiplexer) getNextMsg() (*arbostypes.MessageWithMetadata, error)
 			break
 		}
 	}
+	return segmentNum, timestamp, blockNumber, submessageNumber
+}
+
+// Returns a message, the segment number that had this message, and real/backend errors
+// parsing errors will be reported to log, return nil msg and nil error
+func (r *inboxMultiplexer) getNextMsg() (*arbostypes.MessageWithMetadata, error) {
+	seqMsg := r.cachedSequencerMessage
+	segmentNum, timestamp, blockNumber, submessageNumber := r.seekSegment()
 	r.cachedSegmentNum = segmentNum
 	r.cachedSegmentTimestamp = timestamp
 	r.cachedSegmentBlockNumber = blockNumber
@@ -521,15 +965,19 @@ func (r *inboxMultiplexer) getNextMsg() (*arbostypes.MessageWithMetadata, error)
 	} else if blockNumber > seqMsg.maxL1Block {
 		blockNumber = seqMsg.maxL1Block
 	}
+	var segment []byte
 	if segmentNum >= uint64(len(seqMsg.segments)) {
 		// after end of batch there might be "virtual" delayedMsgSegments
-		log.Warn("reading virtual delayed message segment", "delayedMessagesRead", r.delayedMessagesRead, "afterDelayedMessages", seqMsg.afterDelayedMessages)
+		log.Warn("reading virtual delayed message segment", r.logContext("delayedMessagesRead", r.delayedMessagesRead, "afterDelayedMessages", seqMsg.afterDelayedMessages)...)
 		segment = []byte{BatchSegmentKindDelayedMessages}
 	} else {
 		segment = seqMsg.segments[int(segmentNum)]
 	}
+	// seekSegment skips zero-length segments, so an empty segment never produces a message of its own: the next
+	// real segment is returned within the same Pop. This check is only defensive and, like all of the parsing here,
+	// changing what it does would change the state transition.
 	if len(segment) == 0 {
-		log.Error("empty sequencer message segment", "sequence", r.cachedSegmentNum, "segmentNum", segmentNum)
+		log.Error("empty sequencer message segment", r.logContext("sequence", r.cachedSegmentNum, "segmentNum", segmentNum)...)
 		return nil, nil
 	}
 	kind := segment[0]
@@ -540,7 +988,7 @@ func (r *inboxMultiplexer) getNextMsg() (*arbostypes.MessageWithMetadata, error)
 		if kind == BatchSegmentKindL2MessageBrotli {
 			decompressed, err := arbcompress.Decompress(segment, arbostypes.MaxL2MessageSize)
 			if err != nil {
-				log.Info("dropping compressed message", "err", err, "delayedMsg", r.delayedMessagesRead)
+				log.Info("dropping compressed message", r.logContext("err", err, "delayedMsg", r.delayedMessagesRead)...)
 				return nil, nil
 			}
 			segment = decompressed
@@ -565,8 +1013,10 @@ func (r *inboxMultiplexer) getNextMsg() (*arbostypes.MessageWithMetadata, error)
 			if segmentNum < uint64(len(seqMsg.segments)) {
 				log.Warn(
 					"attempt to read past batch delayed message count",
-					"delayedMessagesRead", r.delayedMessagesRead,
-					"batchAfterDelayedMessages", seqMsg.afterDelayedMessages,
+					r.logContext(
+						"delayedMessagesRead", r.delayedMessagesRead,
+						"batchAfterDelayedMessages", seqMsg.afterDelayedMessages,
+					)...,
 				)
 			}
 			msg = &arbostypes.MessageWithMetadata{
@@ -585,7 +1035,7 @@ func (r *inboxMultiplexer) getNextMsg() (*arbostypes.MessageWithMetadata, error)
 			}
 		}
 	} else {
-		log.Error("bad sequencer message segment kind", "sequence", r.cachedSegmentNum, "segmentNum", segmentNum, "kind", kind)
+		log.Error("bad sequencer message segment kind", r.logContext("sequence", r.cachedSegmentNum, "segmentNum", segmentNum, "kind", kind)...)
 		return nil, nil
 	}
 	return msg, nil
@@ -594,3 +1044,41 @@ func (r *inboxMultiplexer) getNextMsg() (*arbostypes.MessageWithMetadata, error)
 func (r *inboxMultiplexer) DelayedMessagesRead() uint64 {
 	return r.delayedMessagesRead
 }
+
+// CurrentBatchBounds returns the L1 header bounds of the cached sequencer message, which getNextMsg clamps
+// message timestamps and block numbers to. ok is false if no sequencer message is cached.
+func (r *inboxMultiplexer) CurrentBatchBounds() (minTimestamp, maxTimestamp, minL1Block, maxL1Block, afterDelayedMessages uint64, ok bool) {
+	seqMsg := r.cachedSequencerMessage
+	if seqMsg == nil {
+		return 0, 0, 0, 0, 0, false
+	}
+	return seqMsg.minTimestamp, seqMsg.maxTimestamp, seqMsg.minL1Block, seqMsg.maxL1Block, seqMsg.afterDelayedMessages, true
+}
+
+// MultiplexerState is a snapshot of the inbox multiplexer's cursors, for diagnosing stuck replays
+type MultiplexerState struct {
+	SequencerMessageCached bool   `json:"sequencerMessageCached"`
+	SequencerMessageNum    uint64 `json:"sequencerMessageNum"`
+	SegmentNum             uint64 `json:"segmentNum"`
+	SubMessageNumber       uint64 `json:"subMessageNumber"`
+	SegmentTimestamp       uint64 `json:"segmentTimestamp"`
+	SegmentBlockNumber     uint64 `json:"segmentBlockNumber"`
+	DelayedMessagesRead    uint64 `json:"delayedMessagesRead"`
+	PositionWithinMessage  uint64 `json:"positionWithinMessage"`
+}
+
+// DebugState reports the multiplexer's cached state without modifying it or the backend.
+// The sequencer message number and cursor fields describe the last message popped from the cached sequencer message,
+// and are zero while SequencerMessageCached isn't set.
+func (r *inboxMultiplexer) DebugState() MultiplexerState {
+	return MultiplexerState{
+		SequencerMessageCached: r.cachedSequencerMessage != nil,
+		SequencerMessageNum:    r.cachedSequencerMessageNum,
+		SegmentNum:             r.cachedSegmentNum,
+		SubMessageNumber:       r.cachedSubMessageNumber,
+		SegmentTimestamp:       r.cachedSegmentTimestamp,
+		SegmentBlockNumber:     r.cachedSegmentBlockNumber,
+		DelayedMessagesRead:    r.delayedMessagesRead,
+		PositionWithinMessage:  r.backend.GetPositionWithinMessage(),
+	}
+}
@@ -0,0 +1,1215 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package arbstate
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/offchainlabs/nitro/arbcompress"
+	"github.com/offchainlabs/nitro/arbos/arbostypes"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/blsSignatures"
+	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/util/blobs"
+	"github.com/offchainlabs/nitro/util/testhelpers"
+	"github.com/offchainlabs/nitro/zeroheavy"
+)
+
+type testDAProvider struct {
+	headerByte byte
+	payload    []byte
+	err        error
+	onRecover  func()
+	// lastMode is the keyset validation mode of the last RecoverPayloadFromBatch call
+	lastMode KeysetValidationMode
+}
+
+func (p *testDAProvider) IsValidHeaderByte(headerByte byte) bool {
+	return headerByte == p.headerByte
+}
+
+func (p *testDAProvider) RecoverPayloadFromBatch(
+	ctx context.Context,
+	batchNum uint64,
+	batchBlockHash common.Hash,
+	sequencerMsg []byte,
+	preimages map[arbutil.PreimageType]map[common.Hash][]byte,
+	keysetValidationMode KeysetValidationMode,
+) ([]byte, error) {
+	p.lastMode = keysetValidationMode
+	if p.onRecover != nil {
+		p.onRecover()
+	}
+	return p.payload, p.err
+}
+
+type testBlobReader struct {
+	blobs []kzg4844.Blob
+	err   error
+	calls int
+}
+
+func (r *testBlobReader) GetBlobs(
+	ctx context.Context,
+	batchBlockHash common.Hash,
+	versionedHashes []common.Hash,
+) ([]kzg4844.Blob, error) {
+	r.calls++
+	return r.blobs, r.err
+}
+
+func (r *testBlobReader) Initialize(ctx context.Context) error {
+	return nil
+}
+
+// testInboxBackend serves a list of batches and an arbitrary number of delayed messages
+type testInboxBackend struct {
+	batches               [][]byte
+	batchSeqNum           uint64
+	positionWithinMessage uint64
+	delayedMessages       uint64
+}
+
+func (b *testInboxBackend) PeekSequencerInbox() ([]byte, common.Hash, error) {
+	if b.batchSeqNum >= uint64(len(b.batches)) {
+		return nil, common.Hash{}, errors.New("reading unknown sequencer batch")
+	}
+	return b.batches[b.batchSeqNum], common.Hash{}, nil
+}
+
+func (b *testInboxBackend) GetSequencerInboxPosition() uint64 {
+	return b.batchSeqNum
+}
+
+func (b *testInboxBackend) AdvanceSequencerInbox() {
+	b.batchSeqNum++
+}
+
+func (b *testInboxBackend) GetPositionWithinMessage() uint64 {
+	return b.positionWithinMessage
+}
+
+func (b *testInboxBackend) SetPositionWithinMessage(pos uint64) {
+	b.positionWithinMessage = pos
+}
+
+func (b *testInboxBackend) ReadDelayedInbox(seqNum uint64) (*arbostypes.L1IncomingMessage, error) {
+	if seqNum >= b.delayedMessages {
+		return nil, errors.New("reading unknown delayed message")
+	}
+	return &arbostypes.TestIncomingMessageWithRequestId, nil
+}
+
+// buildBrotliBatch returns a sequencer message whose payload is the brotli-compressed RLP list of segments
+func buildBrotliBatch(t *testing.T, afterDelayedMessages uint64, segments ...[]byte) []byte {
+	t.Helper()
+	var rlpData []byte
+	for _, segment := range segments {
+		encoded, err := rlp.EncodeToBytes(segment)
+		Require(t, err)
+		rlpData = append(rlpData, encoded...)
+	}
+	compressed, err := arbcompress.CompressWell(rlpData)
+	Require(t, err)
+	return buildSequencerMessage(afterDelayedMessages, append([]byte{BrotliMessageHeaderByte}, compressed...))
+}
+
+func l2MessageSegment(msg string) []byte {
+	return append([]byte{BatchSegmentKindL2Message}, msg...)
+}
+
+type testDASReader struct {
+	preimages map[common.Hash][]byte
+}
+
+func (r *testDASReader) GetByHash(ctx context.Context, hash common.Hash) ([]byte, error) {
+	preimage, ok := r.preimages[hash]
+	if !ok {
+		return nil, errors.New("preimage not found")
+	}
+	return preimage, nil
+}
+
+func (r *testDASReader) ExpirationPolicy(ctx context.Context) (ExpirationPolicy, error) {
+	return KeepForever, nil
+}
+
+// serializeDASCert mirrors das.Serialize, which can't be imported here
+func serializeDASCert(c *DataAvailabilityCertificate) []byte {
+	flags := DASMessageHeaderFlag
+	if c.Version != 0 {
+		flags |= TreeDASMessageHeaderFlag
+	}
+	buf := append([]byte{flags}, c.KeysetHash[:]...)
+	buf = append(buf, c.SerializeSignableFields()...)
+	buf = binary.BigEndian.AppendUint64(buf, c.SignersMask)
+	return append(buf, blsSignatures.SignatureToBytes(c.Sig)...)
+}
+
+type dasBatchFixture struct {
+	reader       *testDASReader
+	cert         *DataAvailabilityCertificate
+	privKey      blsSignatures.PrivateKey
+	maxTimestamp uint64
+}
+
+// newDASBatchFixture stores a single-signer keyset and the payload, and signs a version 1 cert for them
+func newDASBatchFixture(t *testing.T, payload []byte) *dasBatchFixture {
+	t.Helper()
+	pubKey, privKey, err := blsSignatures.GenerateKeys()
+	Require(t, err)
+	keyset := &DataAvailabilityKeyset{AssumedHonest: 1, PubKeys: []blsSignatures.PublicKey{pubKey}}
+	keysetBuf := new(bytes.Buffer)
+	Require(t, keyset.Serialize(keysetBuf))
+	keysetHash, err := keyset.Hash()
+	Require(t, err)
+	maxTimestamp := uint64(1_000_000)
+	fixture := &dasBatchFixture{
+		reader: &testDASReader{preimages: map[common.Hash][]byte{
+			keysetHash:            keysetBuf.Bytes(),
+			dastree.Hash(payload): payload,
+		}},
+		cert: &DataAvailabilityCertificate{
+			KeysetHash:  keysetHash,
+			DataHash:    dastree.Hash(payload),
+			Timeout:     maxTimestamp + MinLifetimeSecondsForDataAvailabilityCert,
+			SignersMask: 1,
+			Version:     1,
+		},
+		privKey:      privKey,
+		maxTimestamp: maxTimestamp,
+	}
+	fixture.sign(t)
+	return fixture
+}
+
+func (f *dasBatchFixture) sign(t *testing.T) {
+	t.Helper()
+	sig, err := blsSignatures.SignMessage(f.privKey, f.cert.SerializeSignableFields())
+	Require(t, err)
+	f.cert.Sig = sig
+}
+
+func (f *dasBatchFixture) sequencerMessage() []byte {
+	data := buildSequencerMessage(0, serializeDASCert(f.cert))
+	binary.BigEndian.PutUint64(data[8:16], f.maxTimestamp)
+	return data
+}
+
+// buildSequencerMessage prepends an L1 header with unbounded time and block ranges to the payload
+func buildSequencerMessage(afterDelayedMessages uint64, payload []byte) []byte {
+	header := make([]byte, 40)
+	binary.BigEndian.PutUint64(header[8:16], math.MaxUint64)
+	binary.BigEndian.PutUint64(header[24:32], math.MaxUint64)
+	binary.BigEndian.PutUint64(header[32:40], afterDelayedMessages)
+	return append(header, payload...)
+}
+
+func TestLargeEncodedDAPayloadIsDecoded(t *testing.T) {
+	// Random data doesn't compress, and zeroheavy encoding expands it by about a fifth
+	segment := append([]byte{BatchSegmentKindL2Message}, testhelpers.RandomizeSlice(make([]byte, 1000))...)
+	encodedSegment, err := rlp.EncodeToBytes(segment)
+	Require(t, err)
+	compressed, err := arbcompress.CompressWell(encodedSegment)
+	Require(t, err)
+	brotliPayload := append([]byte{BrotliMessageHeaderByte}, compressed...)
+	zeroheavyEncoded, err := io.ReadAll(zeroheavy.NewZeroheavyEncoder(bytes.NewReader(brotliPayload)))
+	Require(t, err)
+	provider := &testDAProvider{
+		headerByte: DASMessageHeaderFlag,
+		payload:    append([]byte{ZeroheavyMessageHeaderFlag}, zeroheavyEncoded...),
+	}
+	config := DefaultInboxMultiplexerConfig
+	config.MaxDecompressedLen = 1100
+	if len(provider.payload) <= config.MaxDecompressedLen {
+		Fail(t, "test payload of", len(provider.payload), "bytes doesn't exceed the max decompressed length")
+	}
+	data := buildSequencerMessage(0, []byte{DASMessageHeaderFlag})
+	parsedMsg, err := parseSequencerMessage(context.Background(), 0, common.Hash{}, data, []DataAvailabilityProvider{provider}, KeysetValidate, &config)
+	Require(t, err)
+	if len(parsedMsg.segments) != 1 || !bytes.Equal(parsedMsg.segments[0], segment) {
+		Fail(t, "expected the encoded payload to decode to its segment")
+	}
+}
+
+func TestHeaderOnlyBlobBatchIsEmpty(t *testing.T) {
+	logHandler := testhelpers.InitTestLog(t, log.LvlWarn)
+	blobReader := &testBlobReader{}
+	data := buildSequencerMessage(0, []byte{BlobHashesHeaderFlag})
+	headerOnlyCounter := metrics.NewCounterForced()
+	daProviders := []DataAvailabilityProvider{&dAProviderForBlobReader{blobReader: blobReader, headerOnlyCounter: headerOnlyCounter}}
+	parsedMsg, err := parseSequencerMessage(context.Background(), 0, common.Hash{}, data, daProviders, KeysetValidate, &DefaultInboxMultiplexerConfig)
+	Require(t, err)
+	if len(parsedMsg.segments) != 0 {
+		Fail(t, "expected no segments from header-only blob batch, got", len(parsedMsg.segments))
+	}
+	if headerOnlyCounter.Count() != 1 {
+		Fail(t, "expected the header-only blob batch to be counted once, got", headerOnlyCounter.Count())
+	}
+	if blobReader.calls != 0 {
+		Fail(t, "blob reader should not be queried without versioned hashes")
+	}
+	if !logHandler.WasLogged("no versioned hashes") {
+		Fail(t, "expected header-only blob batch to be logged")
+	}
+}
+
+// newTestParseCounters returns parse failure counters which count whether or not metrics are enabled
+func newTestParseCounters() *parseFailureCounters {
+	return &parseFailureCounters{
+		brotliError:          metrics.NewCounterForced(),
+		unknownHeaderByte:    metrics.NewCounterForced(),
+		segmentOverflow:      metrics.NewCounterForced(),
+		segmentBytesOverflow: metrics.NewCounterForced(),
+		emptyMessage:         metrics.NewCounterForced(),
+		missingDASReader:     metrics.NewCounterForced(),
+	}
+}
+
+func TestParseFailureCounters(t *testing.T) {
+	// Each empty RLP byte string encodes as 0x80
+	tooManySegments, err := arbcompress.CompressWell(bytes.Repeat([]byte{0x80}, MaxSegmentsPerSequencerMessage+1))
+	Require(t, err)
+	// Zeroheavy decoding itself doesn't fail; a bad decoded payload is counted by the stage after it
+	zeroheavyUnknown, err := io.ReadAll(zeroheavy.NewZeroheavyEncoder(bytes.NewReader([]byte{0x01, 0x02})))
+	Require(t, err)
+	testCases := []struct {
+		name        string
+		payload     []byte
+		daProviders []DataAvailabilityProvider
+		counter     func(*parseFailureCounters) metrics.Counter
+	}{
+		{"empty", nil, nil, func(c *parseFailureCounters) metrics.Counter { return c.emptyMessage }},
+		{"unknown header byte", []byte{0x01, 0x02}, nil, func(c *parseFailureCounters) metrics.Counter { return c.unknownHeaderByte }},
+		{"brotli error", []byte{BrotliMessageHeaderByte, 0xff, 0xff, 0xff}, nil, func(c *parseFailureCounters) metrics.Counter { return c.brotliError }},
+		{"segment overflow", append([]byte{BrotliMessageHeaderByte}, tooManySegments...), nil, func(c *parseFailureCounters) metrics.Counter { return c.segmentOverflow }},
+		{"missing DAS reader", []byte{DASMessageHeaderFlag}, nil, func(c *parseFailureCounters) metrics.Counter { return c.missingDASReader }},
+		{"zeroheavy then unknown header byte", append([]byte{ZeroheavyMessageHeaderFlag}, zeroheavyUnknown...), nil, func(c *parseFailureCounters) metrics.Counter { return c.unknownHeaderByte }},
+	}
+	for _, tc := range testCases {
+		counters := newTestParseCounters()
+		_, err := parseSequencerMessageWithCounters(context.Background(), 0, common.Hash{}, buildSequencerMessage(0, tc.payload), tc.daProviders, KeysetValidate, &DefaultInboxMultiplexerConfig, counters)
+		Require(t, err, tc.name)
+		// Each failure is counted exactly once, by its own counter
+		allCounters := []metrics.Counter{
+			counters.brotliError,
+			counters.unknownHeaderByte,
+			counters.segmentOverflow,
+			counters.segmentBytesOverflow,
+			counters.emptyMessage,
+			counters.missingDASReader,
+		}
+		for i, counter := range allCounters {
+			var expected int64
+			if counter == tc.counter(counters) {
+				expected = 1
+			}
+			if counter.Count() != expected {
+				Fail(t, tc.name, "counter", i, "counted", counter.Count(), "failures")
+			}
+		}
+	}
+}
+
+func TestInboxMultiplexerConfigOverridesSegmentLimit(t *testing.T) {
+	compressed, err := arbcompress.CompressWell(bytes.Repeat([]byte{0x80}, 10))
+	Require(t, err)
+	data := buildSequencerMessage(0, append([]byte{BrotliMessageHeaderByte}, compressed...))
+	config := DefaultInboxMultiplexerConfig
+	config.MaxSegmentsPerSequencerMessage = 4
+	parsedMsg, err := parseSequencerMessage(context.Background(), 0, common.Hash{}, data, nil, KeysetValidate, &config)
+	Require(t, err)
+	if len(parsedMsg.segments) != 4 {
+		Fail(t, "expected segments to be capped at 4, got", len(parsedMsg.segments))
+	}
+	parsedMsg, err = parseSequencerMessage(context.Background(), 0, common.Hash{}, data, nil, KeysetValidate, &DefaultInboxMultiplexerConfig)
+	Require(t, err)
+	if len(parsedMsg.segments) != 10 {
+		Fail(t, "expected 10 segments under the default config, got", len(parsedMsg.segments))
+	}
+}
+
+func TestInboxMultiplexerConfigOverridesDecompressedLen(t *testing.T) {
+	encodedSegment, err := rlp.EncodeToBytes(append([]byte{BatchSegmentKindL2Message}, make([]byte, 1024)...))
+	Require(t, err)
+	compressed, err := arbcompress.CompressWell(encodedSegment)
+	Require(t, err)
+	data := buildSequencerMessage(0, append([]byte{BrotliMessageHeaderByte}, compressed...))
+	config := DefaultInboxMultiplexerConfig
+	config.MaxDecompressedLen = 512
+	counters := newTestParseCounters()
+	parsedMsg, err := parseSequencerMessageWithCounters(context.Background(), 0, common.Hash{}, data, nil, KeysetValidate, &config, counters)
+	Require(t, err)
+	if len(parsedMsg.segments) != 0 || counters.brotliError.Count() != 1 {
+		Fail(t, "expected the batch to exceed the overridden max decompressed length")
+	}
+	parsedMsg, err = parseSequencerMessage(context.Background(), 0, common.Hash{}, data, nil, KeysetValidate, &DefaultInboxMultiplexerConfig)
+	Require(t, err)
+	if len(parsedMsg.segments) != 1 {
+		Fail(t, "expected the batch to decode under the default config")
+	}
+}
+
+func TestInboxMultiplexerConfigValidate(t *testing.T) {
+	config := DefaultInboxMultiplexerConfig
+	Require(t, config.Validate())
+	config.MaxDecompressedLen = 0
+	if _, err := NewInboxMultiplexerWithConfig(&multiplexerBackend{}, 0, nil, KeysetValidate, &config); err == nil {
+		Fail(t, "expected zero max decompressed length to be rejected")
+	}
+	config.MaxDecompressedLen = math.MaxInt
+	if err := config.Validate(); err == nil {
+		Fail(t, "expected an unbounded max decompressed length to be rejected")
+	}
+}
+
+func TestInboxMultiplexerCopiesConfig(t *testing.T) {
+	batch := buildBrotliBatch(t, 0, l2MessageSegment("first"), l2MessageSegment("second"))
+	config := DefaultInboxMultiplexerConfig
+	config.MaxSegmentsPerSequencerMessage = 1
+	multiplexer := newInboxMultiplexer(&multiplexerBackend{batch: batch}, 0, nil, KeysetValidate, &config)
+	config.MaxSegmentsPerSequencerMessage = 2
+	Require(t, multiplexer.loadSequencerMessage(context.Background()))
+	if len(multiplexer.cachedSequencerMessage.segments) != 1 {
+		Fail(t, "changing the config after construction affected parsing")
+	}
+}
+
+func TestRecoverPayloadFromDasBatch(t *testing.T) {
+	payload := []byte("DAS batch payload")
+	fixture := newDASBatchFixture(t, payload)
+	recovered, err := RecoverPayloadFromDasBatch(context.Background(), 0, fixture.sequencerMessage(), fixture.reader, nil, KeysetValidate)
+	Require(t, err)
+	if !bytes.Equal(recovered, payload) {
+		Fail(t, "recovered payload", recovered, "does not match", payload)
+	}
+}
+
+func TestRecoverPayloadFromDasBatchErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		corrupt  func(t *testing.T, f *dasBatchFixture) []byte
+		expected error
+	}{
+		{
+			"truncated cert",
+			func(t *testing.T, f *dasBatchFixture) []byte {
+				return buildSequencerMessage(0, []byte{DASMessageHeaderFlag})
+			},
+			ErrBadDasCert,
+		},
+		{
+			"future version",
+			func(t *testing.T, f *dasBatchFixture) []byte {
+				f.cert.Version = 2
+				return f.sequencerMessage()
+			},
+			ErrUnsupportedDasCertVersion,
+		},
+		{
+			"bad keyset",
+			func(t *testing.T, f *dasBatchFixture) []byte {
+				badKeyset := []byte{1, 2, 3}
+				f.cert.KeysetHash = dastree.Hash(badKeyset)
+				f.reader.preimages[f.cert.KeysetHash] = badKeyset
+				f.sign(t)
+				return f.sequencerMessage()
+			},
+			ErrBadDasKeyset,
+		},
+		{
+			"bad signature",
+			func(t *testing.T, f *dasBatchFixture) []byte {
+				f.cert.Timeout++
+				return f.sequencerMessage()
+			},
+			ErrBadDasSignature,
+		},
+		{
+			"expires too soon",
+			func(t *testing.T, f *dasBatchFixture) []byte {
+				f.cert.Timeout--
+				f.sign(t)
+				return f.sequencerMessage()
+			},
+			ErrCertExpiresTooSoon,
+		},
+	}
+	for _, tc := range testCases {
+		fixture := newDASBatchFixture(t, []byte("DAS batch payload"))
+		data := tc.corrupt(t, fixture)
+		_, err := RecoverPayloadFromDasBatch(context.Background(), 0, data, fixture.reader, nil, KeysetValidate)
+		if !errors.Is(err, tc.expected) {
+			Fail(t, tc.name, "expected", tc.expected, "got", err)
+		}
+		if !errors.Is(err, ErrInvalidDasBatch) {
+			Fail(t, tc.name, "error should wrap ErrInvalidDasBatch", err)
+		}
+		// The multiplexer must keep treating these batches as empty
+		daProviders := []DataAvailabilityProvider{NewDAProviderDAS(fixture.reader)}
+		parsedMsg, err := parseSequencerMessage(context.Background(), 0, common.Hash{}, data, daProviders, KeysetValidate, &DefaultInboxMultiplexerConfig)
+		Require(t, err, tc.name)
+		if len(parsedMsg.segments) != 0 {
+			Fail(t, tc.name, "expected an empty batch, got", len(parsedMsg.segments), "segments")
+		}
+	}
+}
+
+// buildBlobBatch encodes payload into blobs and returns them with a sequencer message listing placeholder versioned hashes
+func buildBlobBatch(t *testing.T, payload []byte) ([]kzg4844.Blob, []byte) {
+	t.Helper()
+	kzgBlobs, err := blobs.EncodeBlobs(payload)
+	Require(t, err)
+	headerAndHashes := []byte{BlobHashesHeaderFlag}
+	for i := range kzgBlobs {
+		headerAndHashes = append(headerAndHashes, common.BigToHash(big.NewInt(int64(i+1))).Bytes()...)
+	}
+	return kzgBlobs, buildSequencerMessage(0, headerAndHashes)
+}
+
+func TestBlobBatchDecodeFailureNamesBlob(t *testing.T) {
+	payload := append([]byte{BrotliMessageHeaderByte}, make([]byte, blobs.BlobEncodableData)...)
+	kzgBlobs, data := buildBlobBatch(t, payload)
+	if len(kzgBlobs) != 2 {
+		Fail(t, "expected payload to span 2 blobs, got", len(kzgBlobs))
+	}
+	provider := NewDAProviderBlobReader(&testBlobReader{blobs: kzgBlobs})
+	recovered, err := provider.RecoverPayloadFromBatch(context.Background(), 0, common.Hash{}, data, nil, KeysetValidate)
+	Require(t, err)
+	if !bytes.Equal(recovered, payload) {
+		Fail(t, "recovered blob payload does not match")
+	}
+	versionedHashes := []common.Hash{common.BigToHash(big.NewInt(1)), common.BigToHash(big.NewInt(2))}
+
+	// The RLP header is at the start of the first blob, so a malformed one is always blamed on it
+	testCases := []struct {
+		name         string
+		header       []byte
+		missingBlobs bool
+	}{
+		// A list header where a string is expected
+		{"list header", []byte{0xc0}, false},
+		// A non-canonical length, which would end in the second blob
+		{"non-canonical length", []byte{0xbb, 0x00, 0x03, 0x00, 0x00}, false},
+		// A valid length of 0x070000 bytes, more than both blobs hold
+		{"length past the last blob", []byte{0xba, 0x07, 0x00, 0x00}, true},
+	}
+	for _, tc := range testCases {
+		logHandler := testhelpers.InitTestLog(t, log.LvlWarn)
+		corrupted := append([]kzg4844.Blob{}, kzgBlobs...)
+		copy(corrupted[0][1:], tc.header)
+		provider = NewDAProviderBlobReader(&testBlobReader{blobs: corrupted})
+		recovered, err = provider.RecoverPayloadFromBatch(context.Background(), 0, common.Hash{}, data, nil, KeysetValidate)
+		Require(t, err, tc.name)
+		if recovered != nil {
+			Fail(t, tc.name, "expected corrupt blobs to be treated as an empty batch")
+		}
+		if tc.missingBlobs {
+			if !logHandler.WasLogged("runs past its last blob") || logHandler.WasLogged("Failed to decode blobs") {
+				Fail(t, tc.name, "expected the decode failure to be logged as missing blobs")
+			}
+			continue
+		}
+		if !logHandler.WasLoggedWith("Failed to decode blobs", "failedVersionedHash", versionedHashes[0]) {
+			Fail(t, tc.name, "expected the decode failure to name versioned hash", versionedHashes[0])
+		}
+		if !logHandler.WasLoggedWith("Failed to decode blobs", "blobIndex", 0) {
+			Fail(t, tc.name, "expected the decode failure to name blob 0")
+		}
+		if logHandler.WasLoggedWith("Failed to decode blobs", "failedVersionedHash", versionedHashes[1]) {
+			Fail(t, tc.name, "the decode failure named the second blob")
+		}
+	}
+}
+
+func TestDAProviderListRouting(t *testing.T) {
+	external := &testDAProvider{headerByte: 0x01, payload: []byte{}}
+	daProviders := NewDAProviderList(&testDASReader{}, external, &testBlobReader{})
+	if len(daProviders) != 3 {
+		Fail(t, "expected 3 providers, got", len(daProviders))
+	}
+	route := func(headerByte byte) DataAvailabilityProvider {
+		for _, provider := range daProviders {
+			if provider.IsValidHeaderByte(headerByte) {
+				return provider
+			}
+		}
+		return nil
+	}
+	if _, ok := route(DASMessageHeaderFlag).(*dAProviderForDAS); !ok {
+		Fail(t, "DAS batch was not routed to the DAS provider")
+	}
+	if _, ok := route(DASMessageHeaderFlag | TreeDASMessageHeaderFlag).(*dAProviderForDAS); !ok {
+		Fail(t, "tree DAS batch was not routed to the DAS provider")
+	}
+	if _, ok := route(BlobHashesHeaderFlag).(*dAProviderForBlobReader); !ok {
+		Fail(t, "blob batch was not routed to the blob provider")
+	}
+	if route(0x01) != external {
+		Fail(t, "external batch was not routed to the external provider")
+	}
+	if route(BrotliMessageHeaderByte) != nil {
+		Fail(t, "calldata batch should not be routed to any provider")
+	}
+	// A header byte with both the DAS and blob bits goes to DAS, as it did before the list existed
+	if _, ok := route(DASMessageHeaderFlag | BlobHashesHeaderFlag).(*dAProviderForDAS); !ok {
+		Fail(t, "overlapping header byte was not routed to the DAS provider")
+	}
+
+	if len(NewDAProviderList(nil, nil, nil)) != 0 {
+		Fail(t, "expected no providers when none are configured")
+	}
+}
+
+func TestCurrentBatchBounds(t *testing.T) {
+	batch := buildBrotliBatch(t, 0, l2MessageSegment("first"), l2MessageSegment("second"))
+	binary.BigEndian.PutUint64(batch[:8], 10)
+	binary.BigEndian.PutUint64(batch[8:16], 20)
+	binary.BigEndian.PutUint64(batch[16:24], 30)
+	binary.BigEndian.PutUint64(batch[24:32], 40)
+	// Tooling only has the exported constructor to go through
+	multiplexer := NewInboxMultiplexer(&multiplexerBackend{batch: batch}, 0, nil, KeysetValidate)
+
+	if _, _, _, _, _, ok := multiplexer.CurrentBatchBounds(); ok {
+		Fail(t, "expected no bounds before a batch is loaded")
+	}
+	_, err := multiplexer.Pop(context.Background())
+	Require(t, err)
+	minTimestamp, maxTimestamp, minL1Block, maxL1Block, afterDelayedMessages, ok := multiplexer.CurrentBatchBounds()
+	if !ok {
+		Fail(t, "expected bounds while the batch is cached")
+	}
+	if minTimestamp != 10 || maxTimestamp != 20 || minL1Block != 30 || maxL1Block != 40 || afterDelayedMessages != 0 {
+		Fail(t, "unexpected bounds", minTimestamp, maxTimestamp, minL1Block, maxL1Block, afterDelayedMessages)
+	}
+	_, err = multiplexer.Pop(context.Background())
+	Require(t, err)
+	if _, _, _, _, _, ok := multiplexer.CurrentBatchBounds(); ok {
+		Fail(t, "expected no bounds after the batch is consumed")
+	}
+}
+
+func advanceTimestampSegment(t *testing.T, delta uint64) []byte {
+	t.Helper()
+	encoded, err := rlp.EncodeToBytes(delta)
+	Require(t, err)
+	return append([]byte{BatchSegmentKindAdvanceTimestamp}, encoded...)
+}
+
+func advanceL1BlockSegment(t *testing.T, delta uint64) []byte {
+	t.Helper()
+	encoded, err := rlp.EncodeToBytes(delta)
+	Require(t, err)
+	return append([]byte{BatchSegmentKindAdvanceL1BlockNumber}, encoded...)
+}
+
+func TestAdvanceOnlyTailWithVirtualDelayedMessages(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		segments             [][]byte
+		afterDelayedMessages uint64
+		// expectedDelayed lists whether each message popped from the batch should be a delayed message
+		expectedDelayed []bool
+	}{
+		{
+			"advance-only tail",
+			[][]byte{l2MessageSegment("msg"), advanceTimestampSegment(t, 1), advanceL1BlockSegment(t, 1)},
+			0,
+			[]bool{false},
+		},
+		{
+			"advance-only tail with a virtual delayed message",
+			[][]byte{l2MessageSegment("msg"), advanceTimestampSegment(t, 1)},
+			1,
+			[]bool{false, true},
+		},
+		{
+			"advance-only batch with virtual delayed messages",
+			[][]byte{advanceTimestampSegment(t, 1), advanceL1BlockSegment(t, 1)},
+			2,
+			[]bool{true, true},
+		},
+		{
+			"delayed segment followed by an advance-only tail and a virtual delayed message",
+			[][]byte{{BatchSegmentKindDelayedMessages}, advanceTimestampSegment(t, 1)},
+			2,
+			[]bool{true, true},
+		},
+	}
+	for _, tc := range testCases {
+		backend := &testInboxBackend{
+			batches:         [][]byte{buildBrotliBatch(t, tc.afterDelayedMessages, tc.segments...)},
+			delayedMessages: tc.afterDelayedMessages,
+		}
+		multiplexer := newInboxMultiplexer(backend, 0, nil, KeysetValidate, &DefaultInboxMultiplexerConfig)
+		for i, expectDelayed := range tc.expectedDelayed {
+			if backend.batchSeqNum != 0 {
+				Fail(t, tc.name, "sequencer message advanced early, before message", i)
+			}
+			msg, err := multiplexer.Pop(context.Background())
+			Require(t, err, tc.name)
+			isDelayed := msg.Message == &arbostypes.TestIncomingMessageWithRequestId
+			if isDelayed != expectDelayed {
+				Fail(t, tc.name, "message", i, "delayed", isDelayed, "expected", expectDelayed)
+			}
+		}
+		if backend.batchSeqNum != 1 || backend.positionWithinMessage != 0 {
+			Fail(t, tc.name, "sequencer message was not advanced after its last message")
+		}
+		if multiplexer.DelayedMessagesRead() != tc.afterDelayedMessages {
+			Fail(t, tc.name, "read", multiplexer.DelayedMessagesRead(), "delayed messages, expected", tc.afterDelayedMessages)
+		}
+	}
+}
+
+func TestPeekNextKind(t *testing.T) {
+	backend := &testInboxBackend{
+		batches: [][]byte{buildBrotliBatch(
+			t, 2,
+			l2MessageSegment("first"),
+			advanceTimestampSegment(t, 5),
+			[]byte{BatchSegmentKindDelayedMessages},
+			l2MessageSegment("second"),
+		)},
+		delayedMessages: 2,
+	}
+	multiplexer := newInboxMultiplexer(backend, 0, nil, KeysetValidate, &DefaultInboxMultiplexerConfig)
+	expectedKinds := []uint8{
+		BatchSegmentKindL2Message,
+		BatchSegmentKindDelayedMessages,
+		BatchSegmentKindL2Message,
+		BatchSegmentKindDelayedMessages, // virtual
+	}
+	for i, expected := range expectedKinds {
+		segmentNum, timestamp, position := multiplexer.cachedSegmentNum, multiplexer.cachedSegmentTimestamp, backend.positionWithinMessage
+		for j := 0; j < 2; j++ {
+			kind, err := multiplexer.PeekNextKind(context.Background())
+			Require(t, err)
+			if kind != expected {
+				Fail(t, "message", i, "peeked kind", kind, "expected", expected)
+			}
+		}
+		if multiplexer.cachedSegmentNum != segmentNum || multiplexer.cachedSegmentTimestamp != timestamp || backend.positionWithinMessage != position {
+			Fail(t, "peeking message", i, "moved the multiplexer's cursors")
+		}
+		_, err := multiplexer.Pop(context.Background())
+		Require(t, err)
+	}
+	if backend.batchSeqNum != 1 {
+		Fail(t, "expected the batch to be consumed")
+	}
+}
+
+func TestPeekNextKindThroughConstructor(t *testing.T) {
+	backend := &testInboxBackend{
+		batches:         [][]byte{buildBrotliBatch(t, 1, l2MessageSegment("first"), []byte{BatchSegmentKindDelayedMessages})},
+		delayedMessages: 1,
+	}
+	multiplexer := NewInboxMultiplexer(backend, 0, nil, KeysetValidate)
+	for i, expected := range []uint8{BatchSegmentKindL2Message, BatchSegmentKindDelayedMessages} {
+		kind, err := multiplexer.PeekNextKind(context.Background())
+		Require(t, err)
+		if kind != expected {
+			Fail(t, "message", i, "peeked kind", kind, "expected", expected)
+		}
+		_, err = multiplexer.Pop(context.Background())
+		Require(t, err)
+	}
+	if multiplexer.DelayedMessagesRead() != 1 {
+		Fail(t, "expected the delayed message to be read once, got", multiplexer.DelayedMessagesRead())
+	}
+}
+
+func TestBlobReaderReturningTooFewBlobs(t *testing.T) {
+	kzgBlobs, data := buildBlobBatch(t, append([]byte{BrotliMessageHeaderByte}, make([]byte, blobs.BlobEncodableData)...))
+	provider := NewDAProviderBlobReader(&testBlobReader{blobs: kzgBlobs[:1]})
+	_, err := provider.RecoverPayloadFromBatch(context.Background(), 0, common.Hash{}, data, nil, KeysetValidate)
+	if err == nil {
+		Fail(t, "expected an error when the blob reader returns fewer blobs than versioned hashes")
+	}
+	// The multiplexer must surface the error so the batch is retried rather than treated as empty
+	_, err = parseSequencerMessage(context.Background(), 0, common.Hash{}, data, []DataAvailabilityProvider{provider}, KeysetValidate, &DefaultInboxMultiplexerConfig)
+	if err == nil {
+		Fail(t, "expected parseSequencerMessage to return the blob count error")
+	}
+}
+
+func TestDAProviderPayloadDecodingStages(t *testing.T) {
+	segments := [][]byte{l2MessageSegment("first"), l2MessageSegment("second")}
+	brotliPayload := buildBrotliBatch(t, 0, segments...)[40:]
+	zeroheavyEncoded, err := io.ReadAll(zeroheavy.NewZeroheavyEncoder(bytes.NewReader(brotliPayload)))
+	Require(t, err)
+	testCases := []struct {
+		name    string
+		payload []byte
+	}{
+		{"brotli", brotliPayload},
+		{"zeroheavy and brotli", append([]byte{ZeroheavyMessageHeaderFlag}, zeroheavyEncoded...)},
+	}
+	for _, tc := range testCases {
+		provider := &testDAProvider{headerByte: 0x01, payload: tc.payload}
+		data := buildSequencerMessage(0, []byte{0x01})
+		parsedMsg, err := parseSequencerMessage(context.Background(), 0, common.Hash{}, data, []DataAvailabilityProvider{provider}, KeysetValidate, &DefaultInboxMultiplexerConfig)
+		Require(t, err, tc.name)
+		if len(parsedMsg.segments) != len(segments) {
+			Fail(t, tc.name, "expected", len(segments), "segments, got", len(parsedMsg.segments))
+		}
+		for i, segment := range parsedMsg.segments {
+			if !bytes.Equal(segment, segments[i]) {
+				Fail(t, tc.name, "segment", i, "does not match")
+			}
+		}
+	}
+}
+
+func TestSegmentLimitsApplyToDAProviderPayloads(t *testing.T) {
+	tooManySegments, err := arbcompress.CompressWell(bytes.Repeat([]byte{0x80}, MaxSegmentsPerSequencerMessage+1))
+	Require(t, err)
+	largeSegment := l2MessageSegment(string(make([]byte, 1000)))
+	testCases := []struct {
+		name             string
+		payload          []byte
+		config           InboxMultiplexerConfig
+		expectedSegments int
+		counter          func(*parseFailureCounters) metrics.Counter
+	}{
+		{
+			"segment count",
+			append([]byte{BrotliMessageHeaderByte}, tooManySegments...),
+			DefaultInboxMultiplexerConfig,
+			MaxSegmentsPerSequencerMessage,
+			func(c *parseFailureCounters) metrics.Counter { return c.segmentOverflow },
+		},
+		{
+			"segment bytes",
+			buildBrotliBatch(t, 0, largeSegment, largeSegment, largeSegment)[40:],
+			InboxMultiplexerConfig{
+				MaxDecompressedLen:                 MaxDecompressedLen,
+				MaxSegmentsPerSequencerMessage:     MaxSegmentsPerSequencerMessage,
+				MaxSegmentBytesPerSequencerMessage: 2 * len(largeSegment),
+			},
+			2,
+			func(c *parseFailureCounters) metrics.Counter { return c.segmentBytesOverflow },
+		},
+	}
+	for _, tc := range testCases {
+		provider := &testDAProvider{headerByte: 0x01, payload: tc.payload}
+		data := buildSequencerMessage(0, []byte{0x01})
+		counters := newTestParseCounters()
+		parsedMsg, err := parseSequencerMessageWithCounters(context.Background(), 0, common.Hash{}, data, []DataAvailabilityProvider{provider}, KeysetValidate, &tc.config, counters)
+		Require(t, err, tc.name)
+		if len(parsedMsg.segments) != tc.expectedSegments {
+			Fail(t, tc.name, "expected", tc.expectedSegments, "segments, got", len(parsedMsg.segments))
+		}
+		if tc.counter(counters).Count() != 1 {
+			Fail(t, tc.name, "overflow was not counted")
+		}
+	}
+}
+
+func TestBatchLogContext(t *testing.T) {
+	blockHash := common.HexToHash("0x1234")
+	logCtx := batchLogContext(7, blockHash, "err", io.EOF)
+	expected := []interface{}{"batchNum", uint64(7), "batchBlockHash", blockHash, "err", io.EOF}
+	if len(logCtx) != len(expected) {
+		Fail(t, "unexpected log context", logCtx)
+	}
+	for i := range expected {
+		if logCtx[i] != expected[i] {
+			Fail(t, "unexpected log context entry", i, logCtx[i], "expected", expected[i])
+		}
+	}
+
+	batch := buildBrotliBatch(t, 0, l2MessageSegment("hello"))
+	backend := &testInboxBackend{batches: [][]byte{batch, batch, batch, batch}, batchSeqNum: 3}
+	multiplexer := newInboxMultiplexer(backend, 0, nil, KeysetValidate, &DefaultInboxMultiplexerConfig)
+	Require(t, multiplexer.loadSequencerMessage(context.Background()))
+	logCtx = multiplexer.logContext()
+	if logCtx[1] != uint64(3) {
+		Fail(t, "multiplexer log context has batch number", logCtx[1])
+	}
+}
+
+func TestParseBatch(t *testing.T) {
+	payload := buildBrotliBatch(t, 2, advanceTimestampSegment(t, 5), l2MessageSegment("hello"), []byte{}, []byte{BatchSegmentKindDelayedMessages})[40:]
+	provider := &testDAProvider{headerByte: DASMessageHeaderFlag, payload: payload}
+	data := buildSequencerMessage(2, []byte{DASMessageHeaderFlag})
+	info, err := ParseBatch(context.Background(), 0, common.Hash{}, data, []DataAvailabilityProvider{provider}, KeysetValidate)
+	Require(t, err)
+	if info.DAProvider != provider {
+		Fail(t, "expected the DAS header byte to be attributed to the test provider")
+	}
+	if info.HeaderByte == nil || *info.HeaderByte != DASMessageHeaderFlag {
+		Fail(t, "unexpected header byte", info.HeaderByte)
+	}
+	if info.AfterDelayedMessages != 2 || info.MaxTimestamp != math.MaxUint64 {
+		Fail(t, "unexpected batch bounds", info)
+	}
+	expectedKinds := []uint8{BatchSegmentKindAdvanceTimestamp, BatchSegmentKindL2Message, BatchSegmentKindDelayedMessages}
+	if !bytes.Equal(info.SegmentKinds, expectedKinds) {
+		Fail(t, "unexpected segment kinds", info.SegmentKinds, "expected", expectedKinds)
+	}
+
+	info, err = ParseBatch(context.Background(), 0, common.Hash{}, buildSequencerMessage(0, nil), nil, KeysetValidate)
+	Require(t, err)
+	if info.HeaderByte != nil || info.DAProvider != nil || len(info.SegmentKinds) != 0 {
+		Fail(t, "unexpected info for batch without payload", info)
+	}
+}
+
+func TestResetMultiplexer(t *testing.T) {
+	ctx := context.Background()
+	backend := &testInboxBackend{
+		batches:         [][]byte{buildBrotliBatch(t, 1, l2MessageSegment("first"), l2MessageSegment("second"))},
+		delayedMessages: 1,
+	}
+	multiplexer := NewInboxMultiplexer(backend, 0, nil, KeysetValidate)
+	msg, err := multiplexer.Pop(ctx)
+	Require(t, err)
+	if string(msg.Message.L2msg) != "first" {
+		Fail(t, "unexpected first message", string(msg.Message.L2msg))
+	}
+
+	// Replace the batch; without a reset the multiplexer keeps using its cached copy
+	backend.batches[0] = buildBrotliBatch(t, 0, l2MessageSegment("replaced"))
+	multiplexer.Reset(0)
+	if backend.positionWithinMessage != 1 || backend.batchSeqNum != 0 {
+		Fail(t, "reset moved the backend", backend.batchSeqNum, backend.positionWithinMessage)
+	}
+	if multiplexer.DelayedMessagesRead() != 0 {
+		Fail(t, "unexpected delayed messages read after reset", multiplexer.DelayedMessagesRead())
+	}
+	backend.positionWithinMessage = 0
+	msg, err = multiplexer.Pop(ctx)
+	Require(t, err)
+	if string(msg.Message.L2msg) != "replaced" {
+		Fail(t, "pop after reset didn't reparse the batch", string(msg.Message.L2msg))
+	}
+	if backend.batchSeqNum != 1 {
+		Fail(t, "expected the single message batch to be consumed")
+	}
+}
+
+func TestEmptySegmentsAreSkipped(t *testing.T) {
+	ctx := context.Background()
+	batch := buildBrotliBatch(t, 1,
+		[]byte{},
+		l2MessageSegment("first"),
+		[]byte{},
+		[]byte{},
+		[]byte{BatchSegmentKindDelayedMessages},
+		[]byte{},
+		l2MessageSegment("last"),
+		[]byte{},
+	)
+	backend := &testInboxBackend{batches: [][]byte{batch}, delayedMessages: 1}
+	multiplexer := newInboxMultiplexer(backend, 0, nil, KeysetValidate, &DefaultInboxMultiplexerConfig)
+
+	msg, err := multiplexer.Pop(ctx)
+	Require(t, err)
+	if msg.Message.Header.Kind != arbostypes.L1MessageType_L2Message || string(msg.Message.L2msg) != "first" {
+		Fail(t, "expected the first L2 message, got", msg.Message)
+	}
+	msg, err = multiplexer.Pop(ctx)
+	Require(t, err)
+	if msg.DelayedMessagesRead != 1 || msg.Message == arbostypes.InvalidL1Message {
+		Fail(t, "expected the delayed message, got", msg)
+	}
+	msg, err = multiplexer.Pop(ctx)
+	Require(t, err)
+	if string(msg.Message.L2msg) != "last" {
+		Fail(t, "expected the last L2 message, got", msg.Message)
+	}
+	// The trailing empty segment doesn't hold the batch open
+	if backend.batchSeqNum != 1 {
+		Fail(t, "expected the batch to be consumed after three messages")
+	}
+}
+
+// cancellingReader cancels its context once it has served cancelAfter bytes
+type cancellingReader struct {
+	reader      io.Reader
+	cancelAfter int
+	read        int
+	cancel      context.CancelFunc
+}
+
+func (r *cancellingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += n
+	if r.read >= r.cancelAfter {
+		r.cancel()
+	}
+	return n, err
+}
+
+func TestParseSequencerMessageCancellation(t *testing.T) {
+	brotliPayload := buildBrotliBatch(t, 0, l2MessageSegment("hello"))[40:]
+	zeroheavyEncoded, err := io.ReadAll(zeroheavy.NewZeroheavyEncoder(bytes.NewReader(brotliPayload)))
+	Require(t, err)
+	for _, payload := range [][]byte{brotliPayload, append([]byte{ZeroheavyMessageHeaderFlag}, zeroheavyEncoded...)} {
+		ctx, cancel := context.WithCancel(context.Background())
+		// Cancel while the payload is being recovered, before it's decoded
+		provider := &testDAProvider{headerByte: DASMessageHeaderFlag, payload: payload, onRecover: cancel}
+		data := buildSequencerMessage(0, []byte{DASMessageHeaderFlag})
+		parsedMsg, err := parseSequencerMessage(ctx, 0, common.Hash{}, data, []DataAvailabilityProvider{provider}, KeysetValidate, &DefaultInboxMultiplexerConfig)
+		if !errors.Is(err, context.Canceled) {
+			Fail(t, "expected cancellation error, got", err)
+		}
+		if parsedMsg != nil {
+			Fail(t, "a cancelled parse must not produce an (empty) batch")
+		}
+	}
+
+	// Cancel once decoding has started; the decoder must stop well before the end of its input
+	largeEncoded, err := io.ReadAll(zeroheavy.NewZeroheavyEncoder(bytes.NewReader(testhelpers.RandomizeSlice(make([]byte, 1024*1024)))))
+	Require(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	source := &cancellingReader{reader: bytes.NewReader(largeEncoded), cancelAfter: 1, cancel: cancel}
+	_, err = decodeZeroheavy(ctx, source, len(largeEncoded)*2)
+	if ctx.Err() == nil {
+		Fail(t, "the source never cancelled the context", err)
+	}
+	if source.read > contextReaderChunkSize {
+		Fail(t, "decoding continued after cancellation, read", source.read, "of", len(largeEncoded))
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	reader := &contextReader{ctx, bytes.NewReader(make([]byte, 16))}
+	buf := make([]byte, 8)
+	_, err = reader.Read(buf)
+	Require(t, err)
+	cancel()
+	if _, err := reader.Read(buf); !errors.Is(err, context.Canceled) {
+		Fail(t, "expected reads to fail after cancellation, got", err)
+	}
+}
+
+func TestStrictHeaderBytes(t *testing.T) {
+	data := buildSequencerMessage(0, []byte{0x01, 0x02})
+	parsedMsg, err := parseSequencerMessage(context.Background(), 0, common.Hash{}, data, nil, KeysetValidate, &DefaultInboxMultiplexerConfig)
+	Require(t, err)
+	if len(parsedMsg.segments) != 0 {
+		Fail(t, "expected an empty batch in lenient mode")
+	}
+
+	config := DefaultInboxMultiplexerConfig
+	config.StrictHeaderBytes = true
+	_, err = parseSequencerMessage(context.Background(), 0, common.Hash{}, data, nil, KeysetValidate, &config)
+	if !errors.Is(err, ErrNoMatchingDAProvider) {
+		Fail(t, "expected ErrNoMatchingDAProvider in strict mode, got", err)
+	}
+	info, err := ParseBatch(context.Background(), 0, common.Hash{}, data, nil, KeysetValidate)
+	Require(t, err)
+	if len(info.SegmentKinds) != 0 {
+		Fail(t, "expected ParseBatch to report an empty batch by default", info.SegmentKinds)
+	}
+	if _, err := ParseBatchWithConfig(context.Background(), 0, common.Hash{}, data, nil, KeysetValidate, &config); !errors.Is(err, ErrNoMatchingDAProvider) {
+		Fail(t, "expected ErrNoMatchingDAProvider from ParseBatchWithConfig in strict mode, got", err)
+	}
+
+	// Known formats and empty payloads are unaffected by strict mode
+	for _, data := range [][]byte{buildBrotliBatch(t, 0, l2MessageSegment("hello")), buildSequencerMessage(0, nil)} {
+		_, err = parseSequencerMessage(context.Background(), 0, common.Hash{}, data, nil, KeysetValidate, &config)
+		Require(t, err)
+	}
+}
+
+func TestPerProviderKeysetValidationMode(t *testing.T) {
+	payload := buildBrotliBatch(t, 0, l2MessageSegment("hello"))[40:]
+	strict := &testDAProvider{headerByte: DASMessageHeaderFlag, payload: payload}
+	relaxed := &testDAProvider{headerByte: 0x01, payload: payload}
+	wrapped, err := NewDAProviderWithKeysetValidationMode(strict, KeysetPanicIfInvalid)
+	Require(t, err)
+	daProviders := []DataAvailabilityProvider{wrapped, relaxed}
+	backend := &testInboxBackend{batches: [][]byte{
+		buildSequencerMessage(0, []byte{DASMessageHeaderFlag}),
+		buildSequencerMessage(0, []byte{0x01}),
+	}}
+	multiplexer := NewInboxMultiplexer(backend, 0, daProviders, KeysetValidate)
+	for i := 0; i < 2; i++ {
+		_, err := multiplexer.Pop(context.Background())
+		Require(t, err)
+	}
+	if strict.lastMode != KeysetPanicIfInvalid {
+		Fail(t, "wrapped provider got keyset validation mode", strict.lastMode)
+	}
+	if relaxed.lastMode != KeysetValidate {
+		Fail(t, "unwrapped provider got keyset validation mode", relaxed.lastMode)
+	}
+	if !daProviders[0].IsValidHeaderByte(DASMessageHeaderFlag) {
+		Fail(t, "wrapped provider lost its header byte")
+	}
+
+	// Replay's switch to KeysetDontValidate for an already validated batch isn't overridden
+	_, err = daProviders[0].RecoverPayloadFromBatch(context.Background(), 0, common.Hash{}, backend.batches[0], nil, KeysetDontValidate)
+	Require(t, err)
+	if strict.lastMode != KeysetDontValidate {
+		Fail(t, "wrapped provider overrode KeysetDontValidate with", strict.lastMode)
+	}
+
+	// Skipping validation would accept batches replay treats as empty, so it can't be chosen per provider
+	if _, err := NewDAProviderWithKeysetValidationMode(strict, KeysetDontValidate); err == nil {
+		Fail(t, "expected KeysetDontValidate to be rejected as a per provider mode")
+	}
+}
+
+func TestBlobReaderCancellation(t *testing.T) {
+	kzgBlobs, seqMsg := buildBlobBatch(t, []byte{BrotliMessageHeaderByte, 1, 2, 3})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// The reader reports an error that doesn't say it was cancelled
+	provider := NewDAProviderBlobReader(&testBlobReader{blobs: kzgBlobs, err: errors.New("connection reset")})
+	payload, err := provider.RecoverPayloadFromBatch(ctx, 0, common.Hash{}, seqMsg, nil, KeysetValidate)
+	if !errors.Is(err, context.Canceled) {
+		Fail(t, "expected cancellation error, got", err)
+	}
+	if payload != nil {
+		Fail(t, "unexpected payload from cancelled fetch")
+	}
+
+	corrupted := append([]kzg4844.Blob{}, kzgBlobs...)
+	corrupted[0][1] = 0xc0
+	provider = NewDAProviderBlobReader(&testBlobReader{blobs: corrupted})
+	payload, err = provider.RecoverPayloadFromBatch(context.Background(), 0, common.Hash{}, seqMsg, nil, KeysetValidate)
+	Require(t, err)
+	if payload != nil {
+		Fail(t, "corrupt blob should be skipped as an empty batch")
+	}
+}
+
+func TestDebugState(t *testing.T) {
+	ctx := context.Background()
+	batch := buildBrotliBatch(t, 0, advanceTimestampSegment(t, 5), l2MessageSegment("a"), l2MessageSegment("b"))
+	// Start at batch 3, so that a stale sequencer message number can't pass for a cleared one
+	backend := &testInboxBackend{batches: [][]byte{nil, nil, nil, batch}, batchSeqNum: 3}
+	multiplexer := NewInboxMultiplexer(backend, 0, nil, KeysetValidate)
+	if state := multiplexer.DebugState(); state != (MultiplexerState{}) {
+		Fail(t, "unexpected initial state", state)
+	}
+
+	_, err := multiplexer.Pop(ctx)
+	Require(t, err)
+	expected := MultiplexerState{
+		SequencerMessageCached: true,
+		SequencerMessageNum:    3,
+		SegmentNum:             1,
+		SegmentTimestamp:       5,
+		PositionWithinMessage:  1,
+	}
+	if state := multiplexer.DebugState(); state != expected {
+		Fail(t, "unexpected state after first pop", state, "expected", expected)
+	}
+	// Reading the state twice must not change it
+	if state := multiplexer.DebugState(); state != expected {
+		Fail(t, "DebugState modified the multiplexer", state)
+	}
+
+	_, err = multiplexer.Pop(ctx)
+	Require(t, err)
+	if state := multiplexer.DebugState(); state != (MultiplexerState{}) {
+		Fail(t, "expected the cache to be cleared after the batch was consumed", state)
+	}
+}
+
+// slowDASReader blocks every GetByHash until its context is done
+type slowDASReader struct {
+	*testDASReader
+}
+
+func (r *slowDASReader) GetByHash(ctx context.Context, hash common.Hash) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestDASFetchTimeout(t *testing.T) {
+	fixture := newDASBatchFixture(t, []byte("DAS batch payload"))
+	data := fixture.sequencerMessage()
+	reader := &slowDASReader{fixture.reader}
+	config := DefaultDASRecoveryConfig
+	config.FetchTimeout = 10 * time.Millisecond
+
+	start := time.Now()
+	_, err := RecoverPayloadFromDasBatchWithConfig(context.Background(), 0, data, reader, nil, KeysetValidate, &config)
+	if !errors.Is(err, ErrDasFetchTimeout) {
+		Fail(t, "expected ErrDasFetchTimeout, got", err)
+	}
+	if errors.Is(err, ErrInvalidDasBatch) {
+		Fail(t, "a fetch timeout must not make the batch empty")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		Fail(t, "fetch timeout took", elapsed)
+	}
+
+	// Cancelling the parent context isn't reported as a fetch timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = RecoverPayloadFromDasBatchWithConfig(ctx, 0, data, reader, nil, KeysetValidate, &config)
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrDasFetchTimeout) {
+		Fail(t, "expected cancellation error, got", err)
+	}
+
+	config.FetchTimeout = -time.Second
+	if err := config.Validate(); err == nil {
+		Fail(t, "expected negative fetch timeout to be rejected")
+	}
+}
+
+func TestDASVersion0FallsBackOnHashMismatch(t *testing.T) {
+	payload := []byte("DAS batch payload")
+	fixture := newDASBatchFixture(t, payload)
+	keysetBytes := fixture.reader.preimages[fixture.cert.KeysetHash]
+	keysetHash := crypto.Keccak256Hash(keysetBytes)
+	dataHash := crypto.Keccak256Hash(payload)
+	fixture.reader.preimages = map[common.Hash][]byte{
+		keysetHash: keysetBytes,
+		dataHash:   payload,
+		// Something other than the payload is stored under the new style hash
+		dastree.FlatHashToTreeHash(dataHash): []byte("unrelated preimage"),
+	}
+	fixture.cert.Version = 0
+	fixture.cert.KeysetHash = keysetHash
+	fixture.cert.DataHash = dataHash
+	fixture.sign(t)
+	data := fixture.sequencerMessage()
+
+	recovered, err := RecoverPayloadFromDasBatch(context.Background(), 0, data, fixture.reader, nil, KeysetValidate)
+	Require(t, err)
+	if !bytes.Equal(recovered, payload) {
+		Fail(t, "recovered wrong payload", recovered)
+	}
+
+	// If the old style hash doesn't match either, the mismatch is still reported
+	fixture.reader.preimages[dataHash] = []byte("another unrelated preimage")
+	_, err = RecoverPayloadFromDasBatch(context.Background(), 0, data, fixture.reader, nil, KeysetValidate)
+	if !errors.Is(err, ErrHashMismatch) {
+		Fail(t, "expected ErrHashMismatch, got", err)
+	}
+}
+
+func Require(t *testing.T, err error, printables ...interface{}) {
+	t.Helper()
+	testhelpers.RequireImpl(t, err, printables...)
+}
+
+func Fail(t *testing.T, printables ...interface{}) {
+	t.Helper()
+	testhelpers.FailImpl(t, printables...)
+}
@@ -0,0 +1,142 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+// Package mocks provides in-memory implementations of the arbstate reader interfaces for use in tests.
+package mocks
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+
+	"github.com/offchainlabs/nitro/arbstate"
+	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/util/blobs"
+)
+
+// DataAvailabilityReader is an arbstate.DataAvailabilityReader serving preimages from memory.
+// Once SetErr is called with a non-nil error, every call fails with it.
+type DataAvailabilityReader struct {
+	mutex     sync.Mutex
+	preimages map[common.Hash][]byte
+	err       error
+	policy    arbstate.ExpirationPolicy
+}
+
+func NewDataAvailabilityReader() *DataAvailabilityReader {
+	return &DataAvailabilityReader{
+		preimages: make(map[common.Hash][]byte),
+		policy:    arbstate.KeepForever,
+	}
+}
+
+// Store records the preimage under its dastree hash, which is how version 1 certificates reference data, and returns the hash
+func (r *DataAvailabilityReader) Store(preimage []byte) common.Hash {
+	hash := dastree.Hash(preimage)
+	r.Set(hash, preimage)
+	return hash
+}
+
+// Set records the preimage under an arbitrary hash, e.g. to serve a mismatching preimage
+func (r *DataAvailabilityReader) Set(hash common.Hash, preimage []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.preimages[hash] = preimage
+}
+
+// SetErr makes every later call fail with err, or succeed again if err is nil
+func (r *DataAvailabilityReader) SetErr(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.err = err
+}
+
+// SetExpirationPolicy sets the policy ExpirationPolicy reports, which is KeepForever by default
+func (r *DataAvailabilityReader) SetExpirationPolicy(policy arbstate.ExpirationPolicy) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.policy = policy
+}
+
+func (r *DataAvailabilityReader) GetByHash(ctx context.Context, hash common.Hash) ([]byte, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	preimage, ok := r.preimages[hash]
+	if !ok {
+		return nil, fmt.Errorf("preimage not found for hash %v", hash)
+	}
+	return preimage, nil
+}
+
+func (r *DataAvailabilityReader) ExpirationPolicy(ctx context.Context) (arbstate.ExpirationPolicy, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err != nil {
+		return -1, r.err
+	}
+	return r.policy, nil
+}
+
+// BlobReader is an arbstate.BlobReader serving blobs from memory by versioned hash.
+// Once SetErr is called with a non-nil error, every call fails with it.
+type BlobReader struct {
+	mutex sync.Mutex
+	blobs map[common.Hash]kzg4844.Blob
+	err   error
+}
+
+func NewBlobReader() *BlobReader {
+	return &BlobReader{
+		blobs: make(map[common.Hash]kzg4844.Blob),
+	}
+}
+
+// Store records the blobs under their versioned hashes and returns the hashes, in order
+func (r *BlobReader) Store(kzgBlobs []kzg4844.Blob) ([]common.Hash, error) {
+	_, versionedHashes, err := blobs.ComputeCommitmentsAndHashes(kzgBlobs)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i, hash := range versionedHashes {
+		r.blobs[hash] = kzgBlobs[i]
+	}
+	return versionedHashes, nil
+}
+
+// SetErr makes every later call fail with err, or succeed again if err is nil
+func (r *BlobReader) SetErr(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.err = err
+}
+
+func (r *BlobReader) GetBlobs(ctx context.Context, batchBlockHash common.Hash, versionedHashes []common.Hash) ([]kzg4844.Blob, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	kzgBlobs := make([]kzg4844.Blob, 0, len(versionedHashes))
+	for _, hash := range versionedHashes {
+		blob, ok := r.blobs[hash]
+		if !ok {
+			return nil, fmt.Errorf("blob not found for versioned hash %v", hash)
+		}
+		kzgBlobs = append(kzgBlobs, blob)
+	}
+	return kzgBlobs, nil
+}
+
+func (r *BlobReader) Initialize(ctx context.Context) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
@@ -0,0 +1,99 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package mocks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/arbstate"
+	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/util/blobs"
+	"github.com/offchainlabs/nitro/util/testhelpers"
+)
+
+func TestDataAvailabilityReader(t *testing.T) {
+	ctx := context.Background()
+	reader := NewDataAvailabilityReader()
+	preimage := []byte("preimage")
+	hash := reader.Store(preimage)
+	if hash != dastree.Hash(preimage) {
+		Fail(t, "stored under unexpected hash", hash)
+	}
+	got, err := reader.GetByHash(ctx, hash)
+	Require(t, err)
+	if !bytes.Equal(got, preimage) {
+		Fail(t, "got wrong preimage", got)
+	}
+	if _, err := reader.GetByHash(ctx, common.Hash{}); err == nil {
+		Fail(t, "expected an error for an unknown hash")
+	}
+
+	policy, err := reader.ExpirationPolicy(ctx)
+	Require(t, err)
+	if policy != arbstate.KeepForever {
+		Fail(t, "unexpected default expiration policy", policy)
+	}
+	reader.SetExpirationPolicy(arbstate.DiscardAfterDataTimeout)
+	policy, err = reader.ExpirationPolicy(ctx)
+	Require(t, err)
+	if policy != arbstate.DiscardAfterDataTimeout {
+		Fail(t, "expiration policy wasn't updated", policy)
+	}
+
+	injected := errors.New("injected")
+	reader.SetErr(injected)
+	if _, err := reader.GetByHash(ctx, hash); !errors.Is(err, injected) {
+		Fail(t, "expected injected error, got", err)
+	}
+	if _, err := reader.ExpirationPolicy(ctx); !errors.Is(err, injected) {
+		Fail(t, "expected injected error from ExpirationPolicy, got", err)
+	}
+}
+
+func TestBlobReaderWithBlobProvider(t *testing.T) {
+	ctx := context.Background()
+	payload := []byte{arbstate.BrotliMessageHeaderByte, 1, 2, 3}
+	kzgBlobs, err := blobs.EncodeBlobs(payload)
+	Require(t, err)
+	reader := NewBlobReader()
+	Require(t, reader.Initialize(ctx))
+	versionedHashes, err := reader.Store(kzgBlobs)
+	Require(t, err)
+
+	sequencerMsg := make([]byte, 40)
+	sequencerMsg = append(sequencerMsg, arbstate.BlobHashesHeaderFlag)
+	for _, hash := range versionedHashes {
+		sequencerMsg = append(sequencerMsg, hash[:]...)
+	}
+	provider := arbstate.NewDAProviderBlobReader(reader)
+	recovered, err := provider.RecoverPayloadFromBatch(ctx, 0, common.Hash{}, sequencerMsg, nil, arbstate.KeysetValidate)
+	Require(t, err)
+	if !bytes.Equal(recovered, payload) {
+		Fail(t, "recovered wrong payload", recovered)
+	}
+
+	injected := errors.New("injected")
+	reader.SetErr(injected)
+	if _, err := provider.RecoverPayloadFromBatch(ctx, 0, common.Hash{}, sequencerMsg, nil, arbstate.KeysetValidate); !errors.Is(err, injected) {
+		Fail(t, "expected injected error, got", err)
+	}
+	if err := reader.Initialize(ctx); !errors.Is(err, injected) {
+		Fail(t, "expected injected error from Initialize, got", err)
+	}
+}
+
+func Require(t *testing.T, err error, printables ...interface{}) {
+	t.Helper()
+	testhelpers.RequireImpl(t, err, printables...)
+}
+
+func Fail(t *testing.T, printables ...interface{}) {
+	t.Helper()
+	testhelpers.FailImpl(t, printables...)
+}
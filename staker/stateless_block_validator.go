@@ -323,7 +323,7 @@ func (v *StatelessBlockValidator) ValidationEntryRecord(ctx context.Context, e *
 				_, err := arbstate.RecoverPayloadFromDasBatch(
 					ctx, batch.Number, batch.Data, v.daService, e.Preimages, arbstate.KeysetValidate,
 				)
-				if err != nil {
+				if err != nil && !errors.Is(err, arbstate.ErrInvalidDasBatch) {
 					return err
 				}
 			}
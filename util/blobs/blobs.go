@@ -6,7 +6,9 @@ package blobs
 import (
 	"bytes"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto/kzg4844"
@@ -75,10 +77,56 @@ func EncodeBlobs(data []byte) ([]kzg4844.Blob, error) {
 	return blobs, nil
 }
 
+// ErrMissingBlobs is wrapped by a DecodeBlobsError when the batch data declares more bytes than its blobs hold
+var ErrMissingBlobs = errors.New("batch data continues past the last blob")
+
+// DecodeBlobsError reports the index of the blob which decoding failed at, as batch data may span many blobs.
+// Malformed batch data is attributed to the first blob, which holds its RLP header. If the data is well formed but
+// runs past the last blob, Err wraps ErrMissingBlobs and BlobIndex is the number of blobs, i.e. the first missing one.
+type DecodeBlobsError struct {
+	BlobIndex int
+	Err       error
+}
+
+func (e *DecodeBlobsError) Error() string {
+	return fmt.Sprintf("failed to decode blob %v: %v", e.BlobIndex, e.Err)
+}
+
+func (e *DecodeBlobsError) Unwrap() error {
+	return e.Err
+}
+
+// rlpStringEnd returns the offset just past the RLP string which starts rlpData, as declared by its header.
+func rlpStringEnd(rlpData []byte) (uint64, error) {
+	if len(rlpData) == 0 {
+		return 0, errors.New("no data")
+	}
+	b := rlpData[0]
+	switch {
+	case b < 0x80:
+		return 1, nil
+	case b < 0xb8:
+		return 1 + uint64(b-0x80), nil
+	case b < 0xc0:
+		lenOfLen := int(b - 0xb7)
+		if len(rlpData) < 1+lenOfLen {
+			return 0, errors.New("truncated string length")
+		}
+		var size uint64
+		for _, lenByte := range rlpData[1 : 1+lenOfLen] {
+			size = size<<8 | uint64(lenByte)
+		}
+		return 1 + uint64(lenOfLen) + size, nil
+	default:
+		return 0, errors.New("expected a string but found a list")
+	}
+}
+
 // DecodeBlobs decodes blobs into the batch data encoded in them.
+// Errors are of type *DecodeBlobsError.
 func DecodeBlobs(blobs []kzg4844.Blob) ([]byte, error) {
 	var rlpData []byte
-	for _, blob := range blobs {
+	for i, blob := range blobs {
 		for fieldIndex := 0; fieldIndex < params.BlobTxFieldElementsPerBlob; fieldIndex++ {
 			rlpData = append(rlpData, blob[fieldIndex*32+1:(fieldIndex+1)*32]...)
 		}
@@ -94,12 +142,20 @@ func DecodeBlobs(blobs []kzg4844.Blob) ([]byte, error) {
 			}
 		}
 		if accBits != 0 {
-			return nil, fmt.Errorf("somehow ended up with %v spare accBits", accBits)
+			return nil, &DecodeBlobsError{BlobIndex: i, Err: fmt.Errorf("somehow ended up with %v spare accBits", accBits)}
 		}
 	}
 	var outputData []byte
 	err := rlp.Decode(bytes.NewReader(rlpData), &outputData)
-	return outputData, err
+	if err != nil {
+		// A well formed header declaring more data than there is, as opposed to e.g. a non-canonical one
+		truncated := errors.Is(err, rlp.ErrValueTooLarge) || errors.Is(err, io.ErrUnexpectedEOF)
+		if end, endErr := rlpStringEnd(rlpData); endErr == nil && end > uint64(len(rlpData)) && truncated {
+			return nil, &DecodeBlobsError{BlobIndex: len(blobs), Err: fmt.Errorf("%w: %w", ErrMissingBlobs, err)}
+		}
+		return nil, &DecodeBlobsError{BlobIndex: 0, Err: err}
+	}
+	return outputData, nil
 }
 
 func CommitmentToVersionedHash(commitment kzg4844.Commitment) common.Hash {
@@ -5,10 +5,12 @@ package blobs
 
 import (
 	"bytes"
+	"errors"
 	"math/big"
 	"math/rand"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
 	"github.com/ethereum/go-ethereum/params"
 )
 
@@ -50,3 +52,50 @@ outer:
 		}
 	}
 }
+
+func TestDecodeBlobsErrorIndex(t *testing.T) {
+	data := make([]byte, bytesEncodedPerBlob*2)
+	_, err := rand.New(rand.NewSource(1)).Read(data)
+	if err != nil {
+		t.Fatalf("failed to generate random bytes: %v", err)
+	}
+	enc, err := EncodeBlobs(data)
+	if err != nil {
+		t.Fatalf("failed to encode blobs: %v", err)
+	}
+	if len(enc) != 3 {
+		t.Fatalf("expected data to span 3 blobs, got %v", len(enc))
+	}
+
+	// Dropping the last blob truncates the data, which is reported as missing blobs
+	_, err = DecodeBlobs(enc[:2])
+	var decodeErr *DecodeBlobsError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected a DecodeBlobsError, got %v", err)
+	}
+	if !errors.Is(err, ErrMissingBlobs) || decodeErr.BlobIndex != 2 {
+		t.Errorf("expected truncation to be reported as missing blob 2, got %v", err)
+	}
+
+	// A non-canonical length declaring more data than there is is a malformed header rather than missing blobs
+	corrupted := append([]kzg4844.Blob{}, enc...)
+	copy(corrupted[0][1:6], []byte{0xbb, 0x00, 0x0f, 0x00, 0x00})
+	_, err = DecodeBlobs(corrupted)
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected a DecodeBlobsError, got %v", err)
+	}
+	if errors.Is(err, ErrMissingBlobs) || decodeErr.BlobIndex != 0 {
+		t.Errorf("expected non-canonical header to be attributed to blob 0, got %v", err)
+	}
+
+	// Corrupting the RLP header in the first blob is attributed to it
+	corrupted = append([]kzg4844.Blob{}, enc...)
+	corrupted[0][1] = 0xc0
+	_, err = DecodeBlobs(corrupted)
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected a DecodeBlobsError, got %v", err)
+	}
+	if decodeErr.BlobIndex != 0 {
+		t.Errorf("expected corrupt header to be attributed to blob 0, got %v", decodeErr.BlobIndex)
+	}
+}
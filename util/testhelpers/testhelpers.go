@@ -72,6 +72,25 @@ func (h *LogHandler) WasLogged(pattern string) bool {
 	return false
 }
 
+// WasLoggedWith is like WasLogged, but the matching record must also have value logged under key
+func (h *LogHandler) WasLoggedWith(pattern string, key string, value interface{}) bool {
+	re, err := regexp.Compile(pattern)
+	RequireImpl(h.t, err)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, record := range h.records {
+		if !re.MatchString(record.Msg) {
+			continue
+		}
+		for i := 0; i+1 < len(record.Ctx); i += 2 {
+			if record.Ctx[i] == key && record.Ctx[i+1] == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func newLogHandler(t *testing.T) *LogHandler {
 	return &LogHandler{
 		t:             t,